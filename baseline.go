@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var baselineFile = flag.String("baseline", "", "JSON file with the expected JVM config of each ProjectName or ProjectID, instances that differ get a failed config_drift result")
+
+// JvmBaseline is the JVM config every instance of a project is expected to run with
+type JvmBaseline struct {
+	Xmx        string            `json:"xmx,omitempty"` // sizes as given to the JVM, e.g. 4g
+	Xms        string            `json:"xms,omitempty"`
+	MaxMeta    string            `json:"maxmeta,omitempty"`
+	Flags      []string          `json:"flags,omitempty"`      // arguments that must be on the command line
+	Properties map[string]string `json:"properties,omitempty"` // system property values
+}
+
+var baselines map[string]JvmBaseline
+
+// jvmArgs are the InputArguments of every JVM, by ServerID and JmxPort, for checking the
+// baseline's Flags. They're only kept with -baseline and never reported.
+var jvmArgs = struct {
+	sync.Mutex
+	jvms map[string][]string
+}{jvms: make(map[string][]string)}
+
+func recordJvmArgs(tomcat TomcatInstance, args []string) {
+	jvmArgs.Lock()
+	defer jvmArgs.Unlock()
+	jvmArgs.jvms[tomcat.ServerID+"|"+tomcat.JmxPort] = args
+}
+
+// argsOf returns the InputArguments last read from an instance's platform JVM, the first of
+// its JmxPorts, or nil when there are none
+func argsOf(tomcat TomcatInstance) []string {
+	port := tomcat.JmxPort
+	if len(tomcat.JmxPorts) > 0 && len(tomcat.JolokiaURL) == 0 {
+		port = tomcat.JmxPorts[0]
+	}
+
+	jvmArgs.Lock()
+	defer jvmArgs.Unlock()
+	return jvmArgs.jvms[tomcat.ServerID+"|"+port]
+}
+
+// loadBaselines reads the -baseline file and adds a read of every system property it names
+func loadBaselines() error {
+	data, err := ioutil.ReadFile(*baselineFile)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &baselines); err != nil {
+		return err
+	}
+
+	properties := make(map[string]bool)
+	for project, baseline := range baselines {
+		for _, size := range []string{baseline.Xmx, baseline.Xms, baseline.MaxMeta} {
+			if _, err := parseJvmSize(size); size != "" && err != nil {
+				return fmt.Errorf("%v: bad size %q", project, size)
+			}
+		}
+		for property := range baseline.Properties {
+			properties[property] = true
+		}
+	}
+
+	for property := range properties {
+		jmxMetrics = append(jmxMetrics, propertyMetric(property))
+	}
+	return nil
+}
+
+// propertyMetric reads one system property out of the Runtime bean's SystemProperties table
+func propertyMetric(property string) JmxMetric {
+	// Jolokia paths are split on slashes, a slash in the key has to be escaped
+	key := strings.Replace(property, "/", "!/", -1)
+	return JmxMetric{
+		Mbean:     "java.lang:type=Runtime",
+		Attribute: "SystemProperties",
+		Path:      key + "/value",
+		DataType:  propertyDataType(property),
+		Static:    true,
+	}
+}
+
+func propertyDataType(property string) string {
+	return "sysprop_" + invalidLabelChars.ReplaceAllString(property, "_")
+}
+
+// baselineFor finds an instance's baseline, ProjectID first like timeoutsFor
+func baselineFor(tomcat TomcatInstance) (JvmBaseline, bool) {
+	baseline, ok := baselines[tomcat.ProjectID]
+	if !ok {
+		baseline, ok = baselines[tomcat.ProjectName]
+	}
+	return baseline, ok
+}
+
+// configDrift reports a config_drift result for every instance with a baseline, failed and
+// listing the differences when its JVM doesn't match. Anything the JVM didn't report, because
+// JMX failed for example, isn't counted as drift.
+func configDrift(instances []TomcatInstance, results []TomcatCheckResult) []TomcatCheckResult {
+	values := make(map[string]map[string]string)
+	for _, result := range results {
+		if values[result.ServerID] == nil {
+			values[result.ServerID] = make(map[string]string)
+		}
+		values[result.ServerID][result.DataType] = result.ServerResponse
+	}
+
+	var drift []TomcatCheckResult
+	for _, tomcat := range instances {
+		baseline, ok := baselineFor(tomcat)
+		reported := values[tomcat.ServerID]
+		if !ok || reported == nil {
+			continue
+		}
+
+		differences := baseline.differences(reported, argsOf(tomcat))
+		if len(differences) == 0 {
+			drift = append(drift, newCheckResult(tomcat, true, "config_drift", "none"))
+			continue
+		}
+		failed := newCheckResult(tomcat, false, "config_drift", strings.Join(differences, "; "))
+		failed.FailureReason = "config drift"
+		drift = append(drift, failed)
+	}
+	return drift
+}
+
+// differences compares the baseline with the values an instance reported, keyed by DataType,
+// and with its JVM arguments. A missing flag is named without its value, which may be secret.
+func (b JvmBaseline) differences(reported map[string]string, args []string) []string {
+	var differences []string
+
+	for _, size := range []struct{ dataType, expected string }{{"xmx", b.Xmx}, {"xms", b.Xms}, {"maxmeta", b.MaxMeta}} {
+		actual, ok := reported[size.dataType]
+		if size.expected == "" || !ok {
+			continue
+		}
+		expected, _ := parseJvmSize(size.expected)
+		if actual != strconv.FormatInt(expected, 10) {
+			differences = append(differences, fmt.Sprintf("%v is %v, expected %v", size.dataType, actual, size.expected))
+		}
+	}
+
+	if args != nil {
+		present := make(map[string]bool)
+		for _, arg := range args {
+			present[arg] = true
+		}
+		for _, required := range b.Flags {
+			if !present[required] {
+				differences = append(differences, "missing "+flagName(required))
+			}
+		}
+	}
+
+	properties := make([]string, 0, len(b.Properties))
+	for property := range b.Properties {
+		properties = append(properties, property)
+	}
+	sort.Strings(properties)
+	for _, property := range properties {
+		expected := b.Properties[property]
+		if actual, ok := reported[propertyDataType(property)]; ok && actual != expected {
+			differences = append(differences, fmt.Sprintf("%v is %q, expected %q", property, actual, expected))
+		}
+	}
+	return differences
+}
+
+// flagName is a JVM argument without its value, -Dkey=value becomes -Dkey
+func flagName(arg string) string {
+	if i := strings.Index(arg, "="); i >= 0 {
+		return arg[:i]
+	}
+	return arg
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigDriftNamesMissingFlags(t *testing.T) {
+	defer func(b map[string]JvmBaseline) { baselines = b }(baselines)
+	baselines = map[string]JvmBaseline{"sakai": {Flags: []string{"-XX:+UseG1GC", "-Dsakai.security=/secret/path"}}}
+
+	tomcat := TomcatInstance{ServerID: "app1", ProjectName: "sakai", JmxPorts: []string{"9000", "9001"}}
+	arguments := []interface{}{"-Xmx4g", "-XX:+UseG1GC", "-Djavax.net.ssl.keyStorePassword=hunter2"}
+	platform, embedded := tomcat, tomcat
+	platform.JmxPort, embedded.JmxPort = "9000", "9001"
+	results := jvmFlagResults(platform, arguments)
+	// The embedded JVM's arguments mustn't count as the instance's
+	jvmFlagResults(embedded, []interface{}{"-Dsakai.security=/secret/path"})
+
+	for _, result := range results {
+		if strings.Contains(result.ServerResponse, "hunter2") {
+			t.Errorf("%v reports the JVM arguments: %q", result.DataType, result.ServerResponse)
+		}
+	}
+
+	drift := configDrift([]TomcatInstance{tomcat}, results)
+	if len(drift) != 1 || drift[0].ServerStatus {
+		t.Fatalf("got %+v, want one failed config_drift", drift)
+	}
+	if drift[0].ServerResponse != "missing -Dsakai.security" {
+		t.Errorf("config_drift is %q, want only the missing flag's name", drift[0].ServerResponse)
+	}
+}
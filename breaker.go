@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var breakerThreshold = flag.Int("breakerThreshold", 5, "failures in a row of one Jolokia endpoint before its remaining reads fail right away as circuit_open, 0 to disable")
+var breakerCooldown = flag.Duration("breakerCooldown", 0, "with -interval, how long an open circuit breaker stays open across runs, 0 closes them at the start of every run")
+
+// breakers track each Jolokia endpoint, so a dead proxy costs a handful of timeouts instead
+// of one for every instance behind it
+var breakers = struct {
+	sync.Mutex
+	endpoints map[string]*breaker
+}{endpoints: make(map[string]*breaker)}
+
+type breaker struct {
+	failures int
+	openedAt time.Time
+}
+
+// resetBreakers closes the breakers at the start of a run, or with -breakerCooldown only the
+// ones that have been open long enough for the endpoint to get another try
+func resetBreakers(now time.Time) {
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	for endpoint, b := range breakers.endpoints {
+		if *breakerCooldown <= 0 || b.openedAt.IsZero() || now.Sub(b.openedAt) >= *breakerCooldown {
+			delete(breakers.endpoints, endpoint)
+		}
+	}
+}
+
+// breakerOpen tells whether reads from an endpoint should fail without being sent
+func breakerOpen(endpoint string) bool {
+	if *breakerThreshold <= 0 {
+		return false
+	}
+
+	breakers.Lock()
+	defer breakers.Unlock()
+	b, ok := breakers.endpoints[endpoint]
+	return ok && !b.openedAt.IsZero()
+}
+
+// recordEndpoint counts a failure of an endpoint towards opening its breaker, a success
+// starts the count over
+func recordEndpoint(endpoint string, ok bool) {
+	if *breakerThreshold <= 0 {
+		return
+	}
+
+	breakers.Lock()
+	defer breakers.Unlock()
+	if ok {
+		delete(breakers.endpoints, endpoint)
+		return
+	}
+
+	b := breakers.endpoints[endpoint]
+	if b == nil {
+		b = &breaker{}
+		breakers.endpoints[endpoint] = b
+	}
+	b.failures++
+	if b.failures >= *breakerThreshold && b.openedAt.IsZero() {
+		b.openedAt = time.Now()
+		logger.Warningf("Jolokia %v failed %v times in a row, failing its remaining reads", redactLog(endpoint), b.failures)
+	}
+}
+
+// circuitOpenResult fails a read that wasn't sent because its endpoint's breaker is open
+func circuitOpenResult(tomcat TomcatInstance, endpoint string) TomcatCheckResult {
+	return jmxFailure(tomcat, "circuit_open", fmt.Sprintf("jolokia %v failed %v times in a row", redactLog(endpoint), *breakerThreshold))
+}
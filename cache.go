@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"sync"
+	"time"
+)
+
+var staticMetricTTL = flag.Duration("staticMetricTTL", 0, "with -interval, how long the results of static metrics like JVM flags are reused before being read again")
+
+// cachedResults are the results of one static metric of one JVM
+type cachedResults struct {
+	results []TomcatCheckResult
+	expires time.Time
+}
+
+var staticCache = struct {
+	sync.Mutex
+	entries map[string]cachedResults
+}{entries: make(map[string]cachedResults)}
+
+// cacheName identifies a metric in the cache, its DataType or what it reads when it reports
+// its own DataTypes
+func (m JmxMetric) cacheName() string {
+	if m.DataType != "" {
+		return m.DataType
+	}
+	return m.Mbean + " " + strings.Join(m.attributeNames(), ",")
+}
+
+// staticCacheKey includes the port, instances with several JmxPorts share a ServerID
+func staticCacheKey(tomcat TomcatInstance, metric JmxMetric) string {
+	return tomcat.ServerID + "|" + tomcat.JmxPort + "|" + metric.cacheName()
+}
+
+// cachedStatic returns the unexpired results of a static metric, if there are any
+func cachedStatic(tomcat TomcatInstance, metric JmxMetric, now time.Time) ([]TomcatCheckResult, bool) {
+	if !metric.Static || *staticMetricTTL <= 0 {
+		return nil, false
+	}
+
+	staticCache.Lock()
+	defer staticCache.Unlock()
+
+	key := staticCacheKey(tomcat, metric)
+	entry, ok := staticCache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if now.After(entry.expires) {
+		delete(staticCache.entries, key)
+		return nil, false
+	}
+	return entry.results, true
+}
+
+// cacheStatic keeps the results of a static metric for -staticMetricTTL
+func cacheStatic(tomcat TomcatInstance, metric JmxMetric, results []TomcatCheckResult, now time.Time) {
+	if !metric.Static || *staticMetricTTL <= 0 {
+		return
+	}
+
+	staticCache.Lock()
+	defer staticCache.Unlock()
+	staticCache.entries[staticCacheKey(tomcat, metric)] = cachedResults{results, now.Add(*staticMetricTTL)}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"strconv"
+	"time"
+)
+
+var maxClockSkew = flag.Duration("maxClockSkew", 30*time.Second, "report clock_skew when Jolokia's clock is further than this from ours")
+
+// clockSkew compares a Jolokia response timestamp with our clock. Jolokia 1.x sends seconds,
+// some agents send milliseconds, anything past the year 5000 in seconds is taken as millis.
+// Only compare timestamps of an agent on the instance (JolokiaURL), the proxy's is its own.
+func clockSkew(timestamp int64, now time.Time) time.Duration {
+	var remote time.Time
+	if timestamp > 100000000000 {
+		remote = time.Unix(0, timestamp*int64(time.Millisecond))
+	} else {
+		remote = time.Unix(timestamp, 0)
+	}
+	return remote.Sub(now)
+}
+
+// clockSkewResult returns a clock_skew result in seconds when the skew exceeds -maxClockSkew.
+// Positive means the remote clock is ahead of ours.
+func clockSkewResult(tomcat TomcatInstance, timestamp int64, now time.Time) (TomcatCheckResult, bool) {
+	if timestamp <= 0 {
+		return TomcatCheckResult{}, false
+	}
+
+	skew := clockSkew(timestamp, now)
+	if math.Abs(float64(skew)) <= float64(*maxClockSkew) {
+		return TomcatCheckResult{}, false
+	}
+
+	logger.Warningf("Clock of %v is off by %v, check NTP", tomcat.ServerID, skew)
+	return newCheckResult(tomcat, true, "clock_skew", strconv.FormatInt(int64(skew/time.Second), 10)), true
+}
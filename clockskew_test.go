@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClockSkewOnlyFromInstanceAgent(t *testing.T) {
+	// Answers every read with a clock an hour ahead
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requests []map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&requests)
+		responses := make([]map[string]interface{}, len(requests))
+		for i, request := range requests {
+			responses[i] = map[string]interface{}{"request": request, "status": 200, "value": 1, "timestamp": time.Now().Add(time.Hour).Unix()}
+		}
+		json.NewEncoder(w).Encode(responses)
+	}))
+	defer server.Close()
+
+	defer func(proxy string) { *jolokiaURL = proxy }(*jolokiaURL)
+	*jolokiaURL = server.URL
+
+	hasSkew := func(results []TomcatCheckResult) bool {
+		for _, result := range results {
+			if result.DataType == "clock_skew" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if results := readJmx(TomcatInstance{ServerID: "proxied", ServerIP: "10.0.0.1", JmxPort: "9000"}, time.Second); hasSkew(results) {
+		t.Error("reported the proxy's clock as the instance's clock_skew")
+	}
+	if results := readJmx(TomcatInstance{ServerID: "agent", JolokiaURL: server.URL}, time.Second); !hasSkew(results) {
+		t.Error("no clock_skew for an agent an hour ahead")
+	}
+}
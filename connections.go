@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// connectionUtilization adds conn_utilization_<port>, the percentage of maxConnections in use,
+// for every connector with both counts. A maxConnections of -1 means unlimited.
+func connectionUtilization(tomcat TomcatInstance, results []TomcatCheckResult) []TomcatCheckResult {
+	maxByPort := make(map[string]float64)
+	for _, result := range results {
+		if port := strings.TrimPrefix(result.DataType, "conn_max_"); port != result.DataType {
+			if v, ok := resultValue(result); ok && v > 0 {
+				maxByPort[port] = v
+			}
+		}
+	}
+
+	var utilization []TomcatCheckResult
+	for _, result := range results {
+		port := strings.TrimPrefix(result.DataType, "conn_current_")
+		if port == result.DataType {
+			continue
+		}
+		current, ok := resultValue(result)
+		maxConns, hasMax := maxByPort[port]
+		if !ok || !hasMax {
+			continue
+		}
+		percent := strconv.FormatFloat(current/maxConns*100, 'f', 2, 64)
+		utilization = append(utilization, newCheckResult(tomcat, true, "conn_utilization_"+port, percent))
+	}
+	return utilization
+}
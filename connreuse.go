@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+// reuseTracker counts how many of an instance's check requests went out on a reused
+// keep-alive connection. A load balancer that breaks connection pinning shows up as requests
+// that never reuse one.
+type reuseTracker struct {
+	sync.Mutex
+	transport http.RoundTripper
+	requests  int
+	reused    int
+}
+
+func (t *reuseTracker) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.Lock()
+			defer t.Unlock()
+			t.requests++
+			if info.Reused {
+				t.reused++
+			}
+		},
+	}
+	return t.transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// result reports conn_reused as 1 when any request reused a connection and 0 when none did.
+// With a single request there was nothing to reuse, so there's no result.
+func (t *reuseTracker) result(tomcat TomcatInstance) (TomcatCheckResult, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.requests < 2 {
+		return TomcatCheckResult{}, false
+	}
+	reused := "0"
+	if t.reused > 0 {
+		reused = "1"
+	}
+	return newCheckResult(tomcat, true, "conn_reused", reused), true
+}
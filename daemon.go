@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+var interval = flag.Duration("interval", 0, "keep running and check every instance this often, 0 checks once and exits")
+
+// runEvery calls run every -interval, timed from the start of each run. A run that takes
+// longer than the interval delays the next one instead of overlapping it. A run that fails,
+// because the admin portal is down say, is logged and the daemon waits for the next one.
+func runEvery(run func() error) {
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		if err := run(); err != nil {
+			msg := redactLog(err.Error())
+			logger.Errorf("Giving up on this run: %v", msg)
+			recordError("%v", msg)
+			writeReport()
+		}
+		<-ticker.C
+	}
+}
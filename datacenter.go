@@ -0,0 +1,50 @@
+package main
+
+import "sort"
+
+// groupByDatacenter splits instances by their Datacenter. Instances without one end up in a
+// single "" group, so a fleet that doesn't set the field is checked exactly as before.
+func groupByDatacenter(instances []TomcatInstance) [][]TomcatInstance {
+	byDatacenter := make(map[string][]TomcatInstance)
+	for _, tomcat := range instances {
+		byDatacenter[tomcat.Datacenter] = append(byDatacenter[tomcat.Datacenter], tomcat)
+	}
+
+	names := make([]string, 0, len(byDatacenter))
+	for name := range byDatacenter {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	groups := make([][]TomcatInstance, 0, len(names))
+	for _, name := range names {
+		groups = append(groups, byDatacenter[name])
+	}
+	return groups
+}
+
+// logDatacenterCounts logs up/down counts per datacenter when the fleet spans more than one
+func logDatacenterCounts(instances []TomcatInstance, results []TomcatCheckResult) {
+	groups := groupByDatacenter(instances)
+	if len(groups) < 2 {
+		return
+	}
+
+	statuses := instanceStatuses(results)
+	for _, group := range groups {
+		up, down := 0, 0
+		for _, tomcat := range group {
+			if result, ok := statuses[tomcat.ServerID]; ok && result.ServerStatus {
+				up++
+			} else {
+				down++
+			}
+		}
+
+		name := group[0].Datacenter
+		if name == "" {
+			name = "(none)"
+		}
+		logger.Infof("  datacenter %v: %v up, %v down", name, up, down)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"sync"
+)
+
+var deltaThreshold = flag.Float64("deltaThreshold", 0, "with -interval, only send the admin portal numeric results that moved by more than this percent since they were last sent, 0 sends everything")
+
+// lastEmitted is the value of every numeric result last sent to the portal, by ServerID and
+// DataType. It lives as long as the daemon.
+var lastEmitted = struct {
+	sync.Mutex
+	values map[[2]string]float64
+}{values: make(map[[2]string]float64)}
+
+// alwaysEmitted are the status results besides liveness (see isLiveness), they're flags rather
+// than measurements so the portal needs them every run
+var alwaysEmitted = map[string]bool{
+	"heartbeat":      true,
+	"degraded":       true,
+	"jmx_degraded":   true,
+	"restarted":      true,
+	"route_mismatch": true,
+	"conn_reused":    true,
+	"config_drift":   true,
+	"skipped":        true,
+}
+
+// deltaFilter drops the numeric results that barely moved since they were last sent. Status,
+// failures and text always go out. The values it lets through are returned as well, they only
+// count as sent once commitEmitted is called with them after the portal took the results.
+func deltaFilter(results []TomcatCheckResult) ([]TomcatCheckResult, map[[2]string]float64) {
+	if *deltaThreshold <= 0 || *interval <= 0 {
+		return results, nil
+	}
+
+	lastEmitted.Lock()
+	defer lastEmitted.Unlock()
+
+	var emitted []TomcatCheckResult
+	pending := make(map[[2]string]float64)
+	for _, result := range results {
+		key := [2]string{result.ServerID, result.DataType}
+		v, numeric := resultValue(result)
+		if !result.ServerStatus {
+			// Forget the last value so the recovery goes out even if it's the same number
+			delete(lastEmitted.values, key)
+		}
+		if !numeric || !result.ServerStatus || isLiveness(result.DataType) || alwaysEmitted[result.DataType] {
+			emitted = append(emitted, result)
+			continue
+		}
+
+		if last, ok := lastEmitted.values[key]; ok && !movedBeyond(last, v, *deltaThreshold) {
+			continue
+		}
+		pending[key] = v
+		emitted = append(emitted, result)
+	}
+
+	if dropped := len(results) - len(emitted); dropped > 0 {
+		logger.Debugf("Left out %v of %v results that moved less than %v%%", dropped, len(results), *deltaThreshold)
+	}
+	return emitted, pending
+}
+
+// sendFiltered posts the results deltaFilter lets through. Their values are only remembered
+// when the portal took them, a failed post leaves them to go out again next run.
+func sendFiltered(results []TomcatCheckResult) error {
+	emitted, sent := deltaFilter(results)
+	if err := updateAdminPortal(emitted); err != nil {
+		return err
+	}
+	commitEmitted(sent)
+	return nil
+}
+
+// commitEmitted remembers the values deltaFilter let through once they reached the portal
+func commitEmitted(sent map[[2]string]float64) {
+	lastEmitted.Lock()
+	defer lastEmitted.Unlock()
+
+	for key, v := range sent {
+		lastEmitted.values[key] = v
+	}
+}
+
+// movedBeyond tells whether v is more than percent away from last
+func movedBeyond(last float64, v float64, percent float64) bool {
+	if last == 0 {
+		return v != 0
+	}
+	return math.Abs(v-last)/math.Abs(last)*100 > percent
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeltaFilterAlwaysEmitsStatus(t *testing.T) {
+	defer func(threshold float64, every time.Duration) { *deltaThreshold, *interval = threshold, every }(*deltaThreshold, *interval)
+	*deltaThreshold, *interval = 10, time.Minute
+
+	run := []TomcatCheckResult{
+		{ServerID: "app1", ServerStatus: true, DataType: "time", ServerResponse: "120"},
+		{ServerID: "app1", ServerStatus: true, DataType: "time_1", ServerResponse: "80"},
+		{ServerID: "app1", ServerStatus: true, DataType: "tcp", ServerResponse: "2"},
+		{ServerID: "app1", ServerStatus: true, DataType: "degraded", ServerResponse: "1"},
+		{ServerID: "app1", ServerStatus: true, DataType: "restarted", ServerResponse: "0"},
+		{ServerID: "app1", ServerStatus: false, DataType: "jmx_port9001", ServerResponse: "jolokia_down"},
+		{ServerID: "app1", ServerStatus: true, DataType: "threads", ServerResponse: "100"},
+	}
+	_, sent := deltaFilter(run)
+	commitEmitted(sent)
+	emitted, _ := deltaFilter(run)
+	if len(emitted) != len(run)-1 || emitted[len(emitted)-1].DataType == "threads" {
+		t.Errorf("emitted %+v, want every result but the unchanged threads", emitted)
+	}
+
+	// A metric that failed goes out again once it recovers, even at its old value
+	failed := TomcatCheckResult{ServerID: "app1", ServerStatus: false, DataType: "threads", ServerResponse: "100"}
+	deltaFilter([]TomcatCheckResult{failed})
+	if emitted, _ := deltaFilter(run[len(run)-1:]); len(emitted) != 1 {
+		t.Error("the recovered threads result wasn't sent")
+	}
+}
+
+func TestDeltaFilterResendsAfterFailedPost(t *testing.T) {
+	defer func(threshold float64, every time.Duration, tok string, transport http.RoundTripper) {
+		*deltaThreshold, *interval, *token, portalClient.Transport = threshold, every, tok, transport
+	}(*deltaThreshold, *interval, *token, portalClient.Transport)
+	portal := &portalReplies{statuses: []int{http.StatusInternalServerError, http.StatusOK}}
+	*deltaThreshold, *interval, *token, portalClient.Transport = 10, time.Minute, "t0ken", portal
+	lastEmitted.Lock()
+	lastEmitted.values = make(map[[2]string]float64)
+	lastEmitted.Unlock()
+
+	run := []TomcatCheckResult{{ServerID: "app9", ServerStatus: true, DataType: "threads", ServerResponse: "100"}}
+	if err := sendFiltered(run); err == nil {
+		t.Fatal("no error from a portal answering 500")
+	}
+
+	// The value the portal never took goes out again, even though it didn't move
+	if err := sendFiltered(run); err != nil {
+		t.Fatal(err)
+	}
+	if len(portal.bodies) != 2 || !strings.Contains(portal.bodies[1], `"DataType":"threads"`) {
+		t.Errorf("the portal got %q, want the threads result resent", portal.bodies)
+	}
+
+	// Once it's been taken it's left out until it moves
+	if err := sendFiltered(run); err != nil {
+		t.Fatal(err)
+	}
+	if len(portal.bodies) != 3 || strings.Contains(portal.bodies[2], `"DataType":"threads"`) {
+		t.Errorf("the portal got %q, want the unchanged threads result left out", portal.bodies)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+var diffReports = flag.Bool("diff", false, "compare two -report files given as arguments, e.g. -diff before.json after.json, and exit")
+var diffThreshold = flag.Float64("diffThreshold", 50, "with -diff, response time growth in percent that counts as a regression")
+
+// loadReport reads a -report file
+func loadReport(path string) (RunReport, error) {
+	var runReport RunReport
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return runReport, err
+	}
+	err = json.Unmarshal(data, &runReport)
+	return runReport, err
+}
+
+// resultsByKey indexes results by ServerID and DataType
+func resultsByKey(results []TomcatCheckResult) map[[2]string]TomcatCheckResult {
+	byKey := make(map[[2]string]TomcatCheckResult)
+	for _, result := range results {
+		byKey[[2]string{result.ServerID, result.DataType}] = result
+	}
+	return byKey
+}
+
+// describeResult is how a result shows up in the diff table
+func describeResult(result TomcatCheckResult, ok bool) string {
+	if !ok {
+		return "-"
+	}
+	if !result.ServerStatus {
+		return result.ServerResponse + " (down)"
+	}
+	return result.ServerResponse
+}
+
+// runDiff prints what changed between two reports and returns the exit code: 1 when an
+// instance went down or its response time grew by more than -diffThreshold percent
+func runDiff(paths []string) int {
+	if len(paths) != 2 {
+		fmt.Println("-diff needs two report files")
+		return 2
+	}
+	before, err := loadReport(paths[0])
+	if err != nil {
+		fmt.Println("Could not load", paths[0], err)
+		return 2
+	}
+	after, err := loadReport(paths[1])
+	if err != nil {
+		fmt.Println("Could not load", paths[1], err)
+		return 2
+	}
+
+	old := resultsByKey(before.Results)
+	current := resultsByKey(after.Results)
+
+	keys := make([][2]string, 0, len(old)+len(current))
+	for key := range old {
+		keys = append(keys, key)
+	}
+	for key := range current {
+		if _, ok := old[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	regressed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE\tMETRIC\tBEFORE\tAFTER\tCHANGE\t")
+	for _, key := range keys {
+		a, inBefore := old[key]
+		b, inAfter := current[key]
+		if inBefore && inAfter && a.ServerStatus == b.ServerStatus && a.ServerResponse == b.ServerResponse {
+			continue
+		}
+
+		change := ""
+		x, okA := resultValue(a)
+		y, okB := resultValue(b)
+		if inBefore && inAfter && okA && okB && x != 0 {
+			growth := (y - x) / x * 100
+			change = strconv.FormatFloat(growth, 'f', 1, 64) + "%"
+			if key[1] == "time" && growth > *diffThreshold {
+				change += " REGRESSED"
+				regressed++
+			}
+		}
+		if inBefore && inAfter && a.ServerStatus && !b.ServerStatus {
+			change += " WENT DOWN"
+			regressed++
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t\n", key[0], key[1], describeResult(a, inBefore), describeResult(b, inAfter), change)
+	}
+	w.Flush()
+
+	fmt.Printf("Up %v -> %v, down %v -> %v, %v regressions\n", before.Up, after.Up, before.Down, after.Down, regressed)
+	if regressed > 0 {
+		return 1
+	}
+	return 0
+}
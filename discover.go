@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+var discoverPattern = flag.String("discover", "", "print the MBeans of the first instance matching this ObjectName pattern, e.g. \"com.zaxxer.hikari:*\", then exit")
+
+// searchRequest builds a Jolokia search, which takes only an ObjectName pattern and answers
+// with the list of matching names instead of attribute values
+func searchRequest(pattern string, jmxURL string) JolokiaRequest {
+	req := JolokiaRequest{Type: "SEARCH", Mbean: pattern}
+	if jmxURL != "" {
+		req.Target = &JolokiaTarget{URL: jmxURL}
+	}
+	return req
+}
+
+// searchMbeans returns the sorted ObjectNames matching pattern
+func searchMbeans(endpoint string, jmxURL string, pattern string, timeout time.Duration) ([]string, error) {
+	respJ, err := postJolokia(endpoint, []JolokiaRequest{searchRequest(pattern, jmxURL)}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if len(respJ) != 1 {
+		return nil, errors.New("no search response")
+	}
+	if respJ[0].Status != http.StatusOK {
+		return nil, fmt.Errorf("search failed: %v", respJ[0].Error)
+	}
+
+	found, ok := respJ[0].Value.([]interface{})
+	if !ok {
+		return nil, errors.New("search response is not a list")
+	}
+	var names []string
+	for _, name := range found {
+		if s, ok := name.(string); ok {
+			names = append(names, s)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// discover prints the MBeans of the first instance matching -discover, to help write a
+// -metrics file
+func discover() {
+	instances, err := getInstancesFromPortal()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if len(instances) == 0 {
+		fatalf("No instances to discover MBeans on")
+	}
+
+	tomcat := instances[0]
+	endpoint, jmxURL := jolokiaEndpoint(tomcat)
+	_, timeout := timeoutsFor(tomcat)
+
+	names, err := searchMbeans(endpoint, jmxURL, *discoverPattern, timeout)
+	if err != nil {
+		fatalf("Could not search %v: %v", tomcat.ServerID, err)
+	}
+
+	fmt.Printf("%v MBeans on %v matching %v\n", len(names), tomcat.ServerID, *discoverPattern)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
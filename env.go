@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+var environment = flag.String("env", "", "environment tag (dev, stage, prod) added to every result")
+var envFromProject = flag.Bool("envFromProject", false, "derive the environment tag from ProjectName when -env isn't set")
+
+// projectEnvironments are looked for in ProjectName, in this order
+var projectEnvironments = []string{"dev", "stage", "test", "prod"}
+
+// environmentFor returns the environment tag of an instance, empty when it isn't known
+func environmentFor(tomcat TomcatInstance) string {
+	if *environment != "" || !*envFromProject {
+		return *environment
+	}
+
+	name := strings.ToLower(tomcat.ProjectName)
+	for _, env := range projectEnvironments {
+		if strings.Contains(name, env) {
+			return env
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultExpectStatus is what the HTTP check has always accepted: the page itself, or the
+// redirect to the login page
+var defaultExpectStatus = []int{http.StatusOK, http.StatusFound}
+
+// httpExpectation is what a check response must satisfy to count as up. Every condition that's
+// set has to hold.
+type httpExpectation struct {
+	status []int
+	body   string // the body must contain this
+	header string // "Name" must be present, "Name: value" must contain value
+}
+
+func expectationFor(tomcat TomcatInstance) httpExpectation {
+	expect := httpExpectation{status: tomcat.ExpectStatus, body: tomcat.ExpectBody, header: tomcat.ExpectHeader}
+	if len(expect.status) == 0 {
+		expect.status = defaultExpectStatus
+	}
+	return expect
+}
+
+// check evaluates the expectation against a response, returning which condition failed or ""
+// when they all hold. The body is read up to -maxBodyBytes, a HEAD check has no body to match.
+func (e httpExpectation) check(resp *http.Response) string {
+	statusOK := false
+	for _, status := range e.status {
+		if resp.StatusCode == status {
+			statusOK = true
+			break
+		}
+	}
+	if !statusOK {
+		return "http status " + strconv.Itoa(resp.StatusCode)
+	}
+
+	if e.header != "" {
+		name, value := e.header, ""
+		if i := strings.Index(e.header, ":"); i >= 0 {
+			name, value = strings.TrimSpace(e.header[:i]), strings.TrimSpace(e.header[i+1:])
+		}
+		got, present := resp.Header[http.CanonicalHeaderKey(name)]
+		if !present {
+			return "missing header " + name
+		}
+		if value != "" && !strings.Contains(strings.Join(got, ", "), value) {
+			return "header " + name + " does not contain " + strconv.Quote(value)
+		}
+	}
+
+	if e.body != "" {
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, *maxBodyBytes))
+		if err != nil {
+			return "could not read body"
+		}
+		if !strings.Contains(string(body), e.body) {
+			return "body does not contain " + strconv.Quote(e.body)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// countingBody tells how much of a response body was read
+type countingBody struct {
+	reader *strings.Reader
+	read   int
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	b.read += n
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	return nil
+}
+
+func TestExpectationBodyCapped(t *testing.T) {
+	defer func(limit int64) { *maxBodyBytes = limit }(*maxBodyBytes)
+	*maxBodyBytes = 1024
+
+	tests := []struct {
+		name   string
+		body   string
+		expect string
+		reason string
+	}{
+		{"match within the cap", "Sakai login" + strings.Repeat("x", 4096), "Sakai login", ""},
+		{"match past the cap", strings.Repeat("x", 4096) + "Sakai login", "Sakai login", `body does not contain "Sakai login"`},
+		{"no match", "Service Unavailable", "Sakai login", `body does not contain "Sakai login"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &countingBody{reader: strings.NewReader(tt.body)}
+			resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(body)}
+
+			expect := httpExpectation{status: defaultExpectStatus, body: tt.expect}
+			if reason := expect.check(resp); reason != tt.reason {
+				t.Errorf("got reason %q, want %q", reason, tt.reason)
+			}
+			if int64(body.read) > *maxBodyBytes {
+				t.Errorf("read %v bytes, more than the %v byte cap", body.read, *maxBodyBytes)
+			}
+		})
+	}
+}
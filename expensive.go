@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"hash/fnv"
+	"math"
+)
+
+var expensiveMetricSample = flag.Float64("expensiveMetricSample", 1, "share of instances, 0-1, whose pattern reads are made each run, rotating through all of them over runs (needs -stateFile when below 1)")
+
+// expensiveRun is the number of this run from the state file, it picks which share of the
+// instances gets its pattern reads
+var expensiveRun int
+
+// expensive metrics read every bean matching a pattern, which can be hundreds on a busy instance
+func (m JmxMetric) expensive() bool {
+	return m.Pattern
+}
+
+// readsExpensive tells whether an instance gets its expensive reads this run. Instances are
+// spread over 1/-expensiveMetricSample buckets by ServerID and each run takes the next bucket,
+// so every instance is covered once per cycle.
+func readsExpensive(tomcat TomcatInstance) bool {
+	if *expensiveMetricSample >= 1 {
+		return true
+	}
+
+	buckets := int(math.Ceil(1 / *expensiveMetricSample))
+	h := fnv.New32a()
+	h.Write([]byte(tomcat.ServerID))
+	return int(h.Sum32()%uint32(buckets)) == expensiveRun%buckets
+}
@@ -0,0 +1,39 @@
+package main
+
+import "strconv"
+
+// fleetTotals maps the per-instance DataTypes that are summed across the fleet to the DataType
+// of their total
+var fleetTotals = []struct {
+	dataType string
+	total    string
+}{
+	{"sessions", "total_sessions"},
+	{"memory", "total_heap_used"},
+	{"threads", "total_threads"},
+}
+
+// fleetResults adds up sessions, heap used and threads over every instance, reported under the
+// _fleet ServerID so the portal doesn't have to sum them. Values that aren't whole numbers are
+// skipped, a total with nothing to add up isn't reported.
+func fleetResults(results []TomcatCheckResult) []TomcatCheckResult {
+	var totals []TomcatCheckResult
+	for _, fleetTotal := range fleetTotals {
+		var sum int64
+		counted := 0
+		for _, result := range results {
+			if result.DataType != fleetTotal.dataType {
+				continue
+			}
+			if v, ok := resultInt(result); ok {
+				sum += v
+				counted++
+			}
+		}
+		if counted == 0 {
+			continue
+		}
+		totals = append(totals, TomcatCheckResult{ServerID: "_fleet", ServerStatus: true, DataType: fleetTotal.total, ServerResponse: strconv.FormatInt(sum, 10), Env: *environment})
+	}
+	return totals
+}
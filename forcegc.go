@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var forceGC = flag.Bool("forceGC", false, "force a GC on every instance and report heap used before and after (needs -confirmForceGC)")
+var confirmForceGC = flag.Bool("confirmForceGC", false, "confirm that forcing GC, which pauses production JVMs, is intended")
+var forceGCWait = flag.Duration("forceGCWait", 2*time.Second, "how long to wait after the forced GC before sampling the heap again")
+
+// heapUsedRequest reads the used heap in bytes
+func heapUsedRequest(jmxURL string) JolokiaRequest {
+	return JmxMetric{Mbean: "java.lang:type=Memory", Attribute: "HeapMemoryUsage", Path: "used"}.request(jmxURL)
+}
+
+// forceGCConcurrency is how many JVMs may be forced to GC at once. It's -jmxConcurrency, but a
+// forced GC pauses the JVM so without a limit they go one at a time rather than all at once.
+func forceGCConcurrency() int {
+	if *jmxConcurrency <= 0 {
+		return 1
+	}
+	return *jmxConcurrency
+}
+
+// forceGarbageCollection samples the heap, runs java.lang:type=Memory gc() and samples it again,
+// reporting heap_before_gc, heap_after_gc and gc_reclaimed
+func forceGarbageCollection(returnChannel chan []TomcatCheckResult, tomcat TomcatInstance, timeout time.Duration) {
+	var results []TomcatCheckResult
+	endpoint, jmxURL := jolokiaEndpoint(tomcat)
+
+	if breakerOpen(endpoint) {
+		logger.Debug("Not forcing GC on", tomcat.ServerID, "the circuit of", redactLog(endpoint), "is open")
+		returnChannel <- results
+		return
+	}
+
+	before, err := readHeapUsed(endpoint, jmxURL, timeout)
+	if err != nil {
+		logger.Debug("Could not read heap before GC", tomcat.ServerID, err)
+		returnChannel <- results
+		return
+	}
+
+	gcRequest := JmxMetric{Type: "exec", Mbean: "java.lang:type=Memory", Operation: "gc"}.request(jmxURL)
+	respJ, err := postJolokia(endpoint, []JolokiaRequest{gcRequest}, timeout)
+	recordGCEndpoint(endpoint, err)
+	if err != nil || len(respJ) != 1 || respJ[0].Status != http.StatusOK {
+		logger.Error("Could not force GC on", tomcat.ServerID, err)
+		returnChannel <- results
+		return
+	}
+
+	time.Sleep(*forceGCWait)
+
+	after, err := readHeapUsed(endpoint, jmxURL, timeout)
+	if err != nil {
+		logger.Debug("Could not read heap after GC", tomcat.ServerID, err)
+		returnChannel <- results
+		return
+	}
+
+	logger.Infof("Forced GC on %v: %v -> %v bytes", tomcat.ServerID, before, after)
+	results = append(results, newCheckResult(tomcat, true, "heap_before_gc", strconv.FormatInt(before, 10)))
+	results = append(results, newCheckResult(tomcat, true, "heap_after_gc", strconv.FormatInt(after, 10)))
+	results = append(results, newCheckResult(tomcat, true, "gc_reclaimed", strconv.FormatInt(before-after, 10)))
+	returnChannel <- results
+}
+
+func readHeapUsed(endpoint string, jmxURL string, timeout time.Duration) (int64, error) {
+	respJ, err := postJolokia(endpoint, []JolokiaRequest{heapUsedRequest(jmxURL)}, timeout)
+	recordGCEndpoint(endpoint, err)
+	if err != nil {
+		return 0, err
+	}
+	if len(respJ) != 1 || respJ[0].Status != http.StatusOK {
+		return 0, errors.New("heap read failed")
+	}
+
+	used, ok := formatJolokiaValue(respJ[0].Value)
+	if !ok {
+		return 0, errors.New("heap used is not a number")
+	}
+	return strconv.ParseInt(used, 10, 64)
+}
+
+// recordGCEndpoint counts a request towards the endpoint's breaker like readJmx does, only
+// failures to talk to Jolokia itself count
+func recordGCEndpoint(endpoint string, err error) {
+	_, unreachable := err.(net.Error)
+	recordEndpoint(endpoint, !unreachable)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForceGCOneAtATime(t *testing.T) {
+	defer func(limit int, wait time.Duration) { *jmxConcurrency, *forceGCWait = limit, wait }(*jmxConcurrency, *forceGCWait)
+	*jmxConcurrency, *forceGCWait = 0, 20*time.Millisecond
+
+	var mu sync.Mutex
+	var inGC, maxInGC, requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requestsJ []map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&requestsJ)
+		mu.Lock()
+		requests++
+		// From the gc() until the heap is read again the JVM counts as paused
+		if requestsJ[0]["operation"] == "gc" {
+			inGC++
+			if inGC > maxInGC {
+				maxInGC = inGC
+			}
+		} else if inGC > 0 {
+			inGC--
+		}
+		mu.Unlock()
+		json.NewEncoder(w).Encode([]map[string]interface{}{{"request": requestsJ[0], "status": 200, "value": 1024}})
+	}))
+	defer server.Close()
+
+	var instances []TomcatInstance
+	for i := 0; i < 4; i++ {
+		instances = append(instances, TomcatInstance{ServerID: "tomcat" + strconv.Itoa(i), JolokiaURL: server.URL})
+	}
+	limit := forceGCConcurrency()
+	results := eachJmx(instances, limit, forceGarbageCollection)
+	if len(results) != 3*len(instances) {
+		t.Errorf("got %v results, want 3 for each of %v instances", len(results), len(instances))
+	}
+	if maxInGC != 1 {
+		t.Errorf("%v JVMs were in a forced GC at once, want 1", maxInGC)
+	}
+
+	// Nothing is sent to an endpoint whose breaker is open
+	defer resetBreakers(time.Now())
+	for i := 0; i < *breakerThreshold; i++ {
+		recordEndpoint(server.URL, false)
+	}
+	requests = 0
+	if results := eachJmx(instances, limit, forceGarbageCollection); len(results) != 0 || requests != 0 {
+		t.Errorf("got %v results and %v requests with the circuit open, want none", len(results), requests)
+	}
+}
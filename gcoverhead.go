@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gcTimePrefix is the DataType prefix of the per-collector CollectionTime results
+const gcTimePrefix = "gc_time_"
+
+// totalGCTime sums the CollectionTime, in ms, of every collector of every instance. Young and
+// old generation collectors both stop the application, so they all count.
+func totalGCTime(results []TomcatCheckResult) map[string]int64 {
+	totals := make(map[string]int64)
+	for _, result := range results {
+		if !strings.HasPrefix(result.DataType, gcTimePrefix) {
+			continue
+		}
+		if v, ok := resultInt(result); ok {
+			totals[result.ServerID] += v
+		}
+	}
+	return totals
+}
+
+// gcOverhead reports the share of wall clock time each instance spent in GC since the previous
+// run as gc_overhead_percent, and remembers the totals for the next run. Call it after update.
+func (s *RunState) gcOverhead(instances []TomcatInstance, results []TomcatCheckResult, now time.Time) []TomcatCheckResult {
+	totals := totalGCTime(results)
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+
+	for _, tomcat := range instances {
+		state, ok := s.Instances[tomcat.ServerID]
+		total, read := totals[tomcat.ServerID]
+		if !ok || !read {
+			continue
+		}
+
+		gcDelta := total - state.GCTime
+		wallDelta := nowMs - state.GCTimeAt
+		// No previous reading, or the JVM restarted and its counters went back to zero
+		if state.GCTimeAt > 0 && gcDelta >= 0 && wallDelta > 0 {
+			overhead := float64(gcDelta) / float64(wallDelta) * 100
+			results = append(results, newCheckResult(tomcat, true, "gc_overhead_percent", strconv.FormatFloat(overhead, 'f', 2, 64)))
+		}
+
+		state.GCTime = total
+		state.GCTimeAt = nowMs
+	}
+	return results
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var graphiteAddr = flag.String("graphite", "", "host:port of a Graphite plaintext listener to send numeric results to")
+
+// invalidGraphiteChars covers the dots that would add levels to the metric path, and whitespace
+// which would end it
+var invalidGraphiteChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+func graphiteName(part string) string {
+	return invalidGraphiteChars.ReplaceAllString(part, "_")
+}
+
+// graphiteLines serializes the numeric results as jmxcron.<serverid>.<datatype> <value> <timestamp>
+func graphiteLines(results []TomcatCheckResult, timestamp time.Time) []byte {
+	var buf bytes.Buffer
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+
+	for _, result := range results {
+		v, ok := resultValue(result)
+		if !ok {
+			continue
+		}
+
+		buf.WriteString("jmxcron.")
+		buf.WriteString(graphiteName(result.ServerID))
+		buf.WriteString(".")
+		buf.WriteString(graphiteName(result.DataType))
+		buf.WriteString(" ")
+		buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		buf.WriteString(" ")
+		buf.WriteString(ts)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// writeToGraphite sends the numeric results over one TCP connection. Errors are logged and
+// never stop the run.
+func writeToGraphite(results []TomcatCheckResult) {
+	body := graphiteLines(results, time.Now())
+	if len(body) == 0 {
+		return
+	}
+
+	conn, err := net.DialTimeout("tcp", *graphiteAddr, 5*time.Second)
+	if err != nil {
+		logger.Error("Could not connect to graphite", err)
+		return
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(body); err != nil {
+		logger.Error("Could not write to graphite", err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"strings"
+)
+
+// headerFlags collects repeated "Name: Value" flags
+type headerFlags http.Header
+
+var jolokiaHeaders = headerFlag("jolokiaHeader", `extra "Name: Value" header sent to Jolokia, can be repeated`)
+
+// headerFlag defines a repeatable header flag. It's a package level var rather than an init
+// func so it's registered before flag.Parse runs.
+func headerFlag(name string, usage string) headerFlags {
+	h := headerFlags{}
+	flag.Var(h, name, usage)
+	return h
+}
+
+func (h headerFlags) String() string {
+	var pairs []string
+	for name, values := range h {
+		for _, value := range values {
+			pairs = append(pairs, name+": "+value)
+		}
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// Set parses and validates one header, flag.Parse exits on the returned error
+func (h headerFlags) Set(header string) error {
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 {
+		return errors.New(`header must look like "Name: Value"`)
+	}
+
+	name := strings.TrimSpace(parts[0])
+	value := strings.TrimSpace(parts[1])
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return errors.New("invalid header name " + name)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("header value can't contain line breaks")
+	}
+
+	http.Header(h).Add(name, value)
+	return nil
+}
+
+// apply sets the headers on an outgoing request
+func (h headerFlags) apply(req *http.Request) {
+	for name, values := range h {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"strconv"
+	"time"
+)
+
+var scoreConfigFile = flag.String("scoreConfig", "", "JSON file with the weights of the health_score reported for every instance")
+
+// ScoreConfig weighs the parts of the health score. A part with no weight isn't scored.
+type ScoreConfig struct {
+	Weights struct {
+		Response float64 `json:"response"`
+		Heap     float64 `json:"heap"`
+		Threads  float64 `json:"threads"`
+		Errors   float64 `json:"errors"`
+	} `json:"weights"`
+	ResponseGoodMs float64 `json:"responseGoodMs"` // at or under this the response part scores 100
+	ResponseBadMs  float64 `json:"responseBadMs"`  // at or over this it scores 0
+	MaxThreads     float64 `json:"maxThreads"`     // thread count that counts as fully utilized
+}
+
+var scoreConfig ScoreConfig
+
+// loadScoreConfig reads and validates the -scoreConfig file
+func loadScoreConfig() error {
+	data, err := ioutil.ReadFile(*scoreConfigFile)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &scoreConfig); err != nil {
+		return err
+	}
+
+	w := scoreConfig.Weights
+	if w.Response < 0 || w.Heap < 0 || w.Threads < 0 || w.Errors < 0 {
+		return errors.New("weights can't be negative")
+	}
+	if w.Response+w.Heap+w.Threads+w.Errors == 0 {
+		return errors.New("no weights set")
+	}
+	if w.Response > 0 && scoreConfig.ResponseBadMs <= scoreConfig.ResponseGoodMs {
+		return errors.New("responseBadMs must be larger than responseGoodMs")
+	}
+	if w.Threads > 0 && scoreConfig.MaxThreads <= 0 {
+		return errors.New("the threads weight needs maxThreads")
+	}
+	return nil
+}
+
+// healthScores reports a 0-100 health_score for every checked instance:
+//
+//	score = sum(weight * part) / sum(weight)
+//
+// over the parts that could be worked out for the instance, each part itself 0-100:
+//   - response: 100 up to responseGoodMs, falling linearly to 0 at responseBadMs, 0 when down
+//   - heap: 100 - used heap as a percentage of -Xmx
+//   - threads: 100 - thread count as a percentage of maxThreads
+//   - errors: 100 - the percentage of the instance's results that failed
+//
+// A part whose metrics weren't collected drops out of both sums, so the remaining weights
+// scale up proportionally instead of the missing part counting as 0.
+func healthScores(instances []TomcatInstance, results []TomcatCheckResult) []TomcatCheckResult {
+	byServer := make(map[string][]TomcatCheckResult)
+	for _, result := range results {
+		byServer[result.ServerID] = append(byServer[result.ServerID], result)
+	}
+
+	var scores []TomcatCheckResult
+	for _, tomcat := range instances {
+		serverResults, ok := byServer[tomcat.ServerID]
+		if !ok {
+			continue
+		}
+		if score, ok := healthScore(serverResults); ok {
+			scores = append(scores, newCheckResult(tomcat, true, "health_score", strconv.FormatFloat(score, 'f', 0, 64)))
+		}
+	}
+	return scores
+}
+
+// healthScore combines the parts of one instance's score
+func healthScore(results []TomcatCheckResult) (float64, bool) {
+	values := make(map[string]float64)
+	var timeResult *TomcatCheckResult
+	failed := 0
+	for i, result := range results {
+		if !result.ServerStatus {
+			failed++
+		}
+		if result.DataType == "time" {
+			timeResult = &results[i]
+		}
+		if v, ok := resultValue(result); ok {
+			values[result.DataType] = v
+		}
+	}
+
+	w := scoreConfig.Weights
+	var total, weights float64
+	add := func(weight float64, part float64) {
+		total += weight * clampPercent(part)
+		weights += weight
+	}
+
+	if w.Response > 0 && timeResult != nil {
+		if !timeResult.ServerStatus {
+			add(w.Response, 0)
+		} else if elapsed, err := parseElapsed(timeResult.ServerResponse); err == nil {
+			ms := float64(elapsed) / float64(time.Millisecond)
+			add(w.Response, 100*(scoreConfig.ResponseBadMs-ms)/(scoreConfig.ResponseBadMs-scoreConfig.ResponseGoodMs))
+		}
+	}
+	if used, xmx := values["memory"], values["xmx"]; w.Heap > 0 && used > 0 && xmx > 0 {
+		add(w.Heap, 100-used/xmx*100)
+	}
+	if threads, ok := values["threads"]; w.Threads > 0 && ok {
+		add(w.Threads, 100-threads/scoreConfig.MaxThreads*100)
+	}
+	if w.Errors > 0 && len(results) > 0 {
+		add(w.Errors, 100-float64(failed)/float64(len(results))*100)
+	}
+
+	if weights == 0 {
+		return 0, false
+	}
+	return total / weights, true
+}
+
+func clampPercent(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 100 {
+		return 100
+	}
+	return v
+}
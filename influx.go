@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var influxURL = flag.String("influx", "", "InfluxDB write endpoint, e.g. http://influx:8086/write?db=jmx, to send numeric results to")
+
+var influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// influxLines serializes the numeric results as InfluxDB line protocol, one measurement per DataType
+func influxLines(results []TomcatCheckResult, timestamp time.Time) []byte {
+	var buf bytes.Buffer
+	ts := strconv.FormatInt(timestamp.UnixNano(), 10)
+
+	for _, result := range results {
+		v, ok := resultValue(result)
+		if !ok {
+			continue
+		}
+
+		buf.WriteString(influxMeasurementEscaper.Replace(result.DataType))
+		buf.WriteString(",server_id=")
+		buf.WriteString(influxTagEscaper.Replace(result.ServerID))
+		buf.WriteString(" value=")
+		buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		buf.WriteString(" ")
+		buf.WriteString(ts)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// writeToInflux POSTs the numeric results to InfluxDB. Errors are logged and never stop the run.
+func writeToInflux(results []TomcatCheckResult) {
+	body := influxLines(results, time.Now())
+	if len(body) == 0 {
+		return
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	req, err := http.NewRequest("POST", *influxURL, bytes.NewReader(body))
+	if err != nil {
+		logger.Error("Could not build influx request", err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("User-Agent", cronUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Could not write to influx", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		logger.Errorf("Bad influx response: %v %v", resp.Status, string(msg))
+	}
+}
@@ -2,32 +2,62 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/alexcesaro/log"
 	"github.com/alexcesaro/log/stdlog"
 )
 
 const adminURL = "https://admin.longsight.com/longsight/json/jmx-instances"
-const cronUserAgent = "JMX-Cron v1.0"
+const version = "1.0"
+const cronUserAgent = "JMX-Cron v" + version
 
 var token = flag.String("token", "", "the custom security token")
 var localIP = flag.String("ips", "", "ips to check")
 var clientID = flag.String("clientID", "", "client id")
 var jolokiaURL = flag.String("jolokia", "http://10.4.100.101:32222/jolokia", "Jolokia endpoint")
 var jolokiaTimeout = flag.Int("timeout", 5, "Jolokia timeout in seconds")
+var jmxConcurrency = flag.Int("jmxConcurrency", 0, "maximum concurrent Jolokia requests, 0 for no limit")
+var maxBodyBytes = flag.Int64("maxBodyBytes", 256<<10, "most bytes of an HTTP check response body that are read")
+var maxJmxBody = flag.Int64("maxJmxBody", 4<<20, "largest Jolokia response body in bytes that will be decoded")
+var emptyRetries = flag.Int("emptyRetries", 0, "times to retry the admin portal when it returns an empty instance list")
+var maxInstances = flag.Int("maxInstances", 5000, "refuse to run when the admin portal returns more instances than this")
+var truncateInstances = flag.Bool("truncateInstances", false, "check the first -maxInstances instances instead of refusing to run")
+var warmup = flag.Bool("warmup", false, "send one untimed request before each timed HTTP check so a cold JVM doesn't skew it")
+var confirmDown = flag.Bool("confirmDown", false, "re-check a failed instance once before reporting it down")
+var confirmPath = flag.String("confirmPath", "", "path for the -confirmDown probe, defaults to the checked path")
+var confirmPort = flag.String("confirmPort", "", "port for the -confirmDown probe, defaults to the HTTP port")
+var selfTest = flag.Bool("selfTest", false, "validate the flags and config files without making any requests, then exit")
+var postBatchSize = flag.Int("postBatchSize", 0, "results per admin portal POST, 0 sends everything at once")
+var postConcurrency = flag.Int("postConcurrency", 1, "admin portal POSTs in flight at once when batching")
+var portalAcceptsGzip = flag.Bool("portalAcceptsGzip", false, "the admin portal accepts gzip encoded POSTs")
+var gzipThreshold = flag.Int("gzipThreshold", 64<<10, "smallest admin portal payload in bytes that is gzipped, with -portalAcceptsGzip")
+var verifyAck = flag.Bool("verifyAck", false, "check the admin portal's {\"accepted\": N} reply against the number of results sent")
+var clientCert = flag.String("clientCert", "", "client certificate (PEM) for mutual TLS with the admin portal")
+var clientKey = flag.String("clientKey", "", "client private key (PEM) for mutual TLS with the admin portal")
 
-//var propertyFiles = [4]string{"instance.properties", "dev.properties", "local.properties", "sakai.properties"}
-var logger = stdlog.GetFromFlags()
-var outputBuffer bytes.Buffer
+// var propertyFiles = [4]string{"instance.properties", "dev.properties", "local.properties", "sakai.properties"}
+// logger is set up from the flags once they're parsed
+var logger log.Logger
+
+// portalClient is used for every request to the admin portal
+var portalClient = &http.Client{}
 
 // TomcatInstance is a tomcat instance from the Longsight admin portal
 type TomcatInstance struct {
@@ -38,6 +68,16 @@ type TomcatInstance struct {
 	JmxPort     string
 	ProjectID   string
 	ProjectName string
+	Method      string // GET (default) or HEAD for the HTTP check
+	Datacenter  string
+	CheckURLs   []string // URLs or paths to check instead of the default page, all must pass
+	JolokiaURL  string   // Jolokia agent on the instance itself, used instead of the -jolokia proxy
+	JmxPorts    []string // JMX ports of every JVM on the host, replaces JmxPort when set
+	// What an HTTP check response must satisfy, by default a 200 or 302 with anything in it
+	ExpectStatus []int
+	ExpectBody   string
+	ExpectHeader string  // "Name" or "Name: value"
+	Weight       float64 // how much the instance counts toward fleet availability, unset means 1
 }
 
 // JolokiaReadResponse is the JSON-encoded info return from the Jolokia JMX proxy
@@ -55,52 +95,489 @@ type TomcatCheckResult struct {
 	ServerStatus   bool
 	DataType       string
 	ServerResponse string
+	FailureReason  string `json:",omitempty"`
+	JvmRoute       string `json:",omitempty"`
+	Env            string `json:",omitempty"`
+}
+
+// newCheckResult builds a result for one DataType of an instance
+func newCheckResult(tomcat TomcatInstance, status bool, dataType string, response string) TomcatCheckResult {
+	return TomcatCheckResult{
+		ServerID:       tomcat.ServerID,
+		ServerStatus:   status,
+		DataType:       dataType,
+		ServerResponse: response,
+		JvmRoute:       tomcat.JvmRoute,
+		Env:            environmentFor(tomcat),
+	}
 }
 
-// JolokiaRequest gets POSTed to Jolokia
+// JolokiaRequest gets POSTed to Jolokia. Attribute is either a single attribute name or a
+// []string to read several attributes of the same MBean at once.
 type JolokiaRequest struct {
-	Type      string `json:"type"`
-	Mbean     string `json:"mbean"`
-	Attribute string `json:"attribute"`
-	Path      string `json:"path"`
-	Target    struct {
-		URL string `json:"url"`
-	} `json:"target"`
+	Type      string         `json:"type"`
+	Mbean     string         `json:"mbean"`
+	Attribute interface{}    `json:"attribute,omitempty"`
+	Path      string         `json:"path,omitempty"`
+	Operation string         `json:"operation,omitempty"`
+	Arguments []interface{}  `json:"arguments,omitempty"`
+	Target    *JolokiaTarget `json:"target,omitempty"`
+}
+
+// JolokiaTarget tells a Jolokia proxy which JVM to forward the request to. Requests sent
+// straight to an agent running in the instance have no target.
+type JolokiaTarget struct {
+	URL string `json:"url"`
 }
 
 // JolokiaRequestResponse Auto-gen from http://mholt.github.io/json-to-go/
+//
+// It's the shape of a Jolokia 1.x response, 2.x responses are decoded with their own struct
+// and converted to it (see decodeJolokia2Body).
 type JolokiaRequestResponse []struct {
-	Timestamp int `json:"timestamp"`
-	Status    int `json:"status"`
+	Timestamp int64 `json:"timestamp"`
+	Status    int   `json:"status"`
 	Request   struct {
 		Mbean  string `json:"mbean"`
 		Path   string `json:"path"`
 		Target struct {
 			URL string `json:"url"`
 		} `json:"target"`
-		Attribute string `json:"attribute"`
-		Type      string `json:"type"`
+		Attribute interface{} `json:"attribute"`
+		Operation string      `json:"operation"`
+		Type      string      `json:"type"`
 	} `json:"request"`
-	Value int64 `json:"value"`
+	Value interface{} `json:"value"`
+	Error string      `json:"error"`
 }
 
-func init() {
+// setup parses the flags and gets everything they configure ready. It runs from main rather
+// than init so the functions can be tested with go test's own flags.
+func setup() {
 	flag.Parse()
-	if len(*token) < 1 {
-		fmt.Println("Please provide a valid security token")
+	logger = stdlog.GetFromFlags()
+
+	// Comparing two reports is offline and needs none of the other flags
+	if *diffReports {
+		os.Exit(runDiff(flag.Args()))
+	}
+
+	if err := configure(); err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
+	// Everything parsed and loaded, a self test stops before making any requests
+	if *selfTest {
+		fmt.Println("OK")
+		os.Exit(0)
+	}
+
+	if oauthConfigured() {
+		if _, err := currentOAuthToken(); err != nil {
+			fmt.Println(redactLog(err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	if len(*ndjsonPath) > 0 {
+		if err := openNDJSON(); err != nil {
+			fmt.Println("Could not open ndjson output:", err)
+			os.Exit(1)
+		}
+	}
+
+	checkTransport = newCheckTransport()
+	jolokiaTransport = newJolokiaTransport()
+	includeGlobs = compileGlobs(*mbeanInclude)
+	excludeGlobs = compileGlobs(*mbeanExclude)
+
 	// Limit the request concurrency
 	runtime.GOMAXPROCS(runtime.NumCPU())
 }
 
+// configure validates the flags and loads the files they point to, returning the first problem
+func configure() error {
+	if err := validateOAuth(); err != nil {
+		return err
+	}
+
+	if len(*token) < 1 && !oauthConfigured() {
+		return errors.New("Please provide a valid security token")
+	}
+
+	endpoints := []struct {
+		name     string
+		rawURL   string
+		required bool
+	}{
+		{"admin portal", adminURL, true},
+		{"jolokia", *jolokiaURL, true},
+		{"pushgateway", *pushgatewayURL, false},
+		{"webhook", *webhookURL, false},
+		{"influx", *influxURL, false},
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.rawURL == "" && !endpoint.required {
+			continue
+		}
+		if u, err := url.Parse(endpoint.rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Invalid %v URL %q", endpoint.name, endpoint.rawURL)
+		}
+	}
+
+	if err := validateMinTLS(); err != nil {
+		return err
+	}
+
+	if err := validateJolokiaVersion(); err != nil {
+		return err
+	}
+
+	portalTLS := newTLSConfig()
+	if len(*clientCert) > 0 || len(*clientKey) > 0 {
+		if len(*clientCert) < 1 || len(*clientKey) < 1 {
+			return errors.New("Both -clientCert and -clientKey are required for mutual TLS")
+		}
+
+		cert, err := tls.LoadX509KeyPair(*clientCert, *clientKey)
+		if err != nil {
+			return fmt.Errorf("Could not load client certificate: %v", err)
+		}
+		portalTLS.Certificates = []tls.Certificate{cert}
+	}
+	portalTransport := http.DefaultTransport.(*http.Transport).Clone()
+	portalTransport.TLSClientConfig = portalTLS
+	portalClient.Transport = traced(portalTransport)
+
+	if len(*dnsServer) > 0 {
+		if _, _, err := net.SplitHostPort(*dnsServer); err != nil {
+			return fmt.Errorf("Invalid -dnsServer %q, use host:port", *dnsServer)
+		}
+	}
+
+	if err := validateTimeUnit(); err != nil {
+		return err
+	}
+
+	if err := validateProbeOrder(); err != nil {
+		return err
+	}
+
+	if err := validatePostProcess(); err != nil {
+		return err
+	}
+
+	if err := deriveTimeouts(); err != nil {
+		return err
+	}
+
+	if len(*projectTimeoutsFile) > 0 {
+		if err := loadProjectTimeouts(); err != nil {
+			return fmt.Errorf("Could not load project timeouts: %v", err)
+		}
+	}
+
+	if len(*sessionCapsFile) > 0 {
+		if err := loadSessionCaps(); err != nil {
+			return fmt.Errorf("Could not load session caps: %v", err)
+		}
+	}
+
+	if len(*metricsFile) > 0 {
+		metrics, err := loadMetrics(*metricsFile)
+		if err != nil {
+			return fmt.Errorf("Could not load metrics: %v", err)
+		}
+		jmxMetrics = metrics
+	}
+
+	if len(*portalSchemaFile) > 0 {
+		if err := loadPortalSchema(); err != nil {
+			return fmt.Errorf("Could not load portal schema: %v", err)
+		}
+	}
+
+	if err := loadSSHTunnels(); err != nil {
+		return fmt.Errorf("Could not load SSH tunnels: %v", err)
+	}
+
+	// After -metrics, the baseline adds to whichever metrics are read
+	if len(*baselineFile) > 0 {
+		if err := loadBaselines(); err != nil {
+			return fmt.Errorf("Could not load baseline: %v", err)
+		}
+	}
+
+	if len(*scoreConfigFile) > 0 {
+		if err := loadScoreConfig(); err != nil {
+			return fmt.Errorf("Could not load score config: %v", err)
+		}
+	}
+
+	if *staticMetricTTL > 0 && *interval <= 0 {
+		return errors.New("The -staticMetricTTL option only applies with an -interval")
+	}
+
+	if *forceGC && !*confirmForceGC {
+		return errors.New("-forceGC pauses every JVM it touches, add -confirmForceGC if that's really intended")
+	}
+
+	if *expensiveMetricSample <= 0 || *expensiveMetricSample > 1 {
+		return errors.New("-expensiveMetricSample must be above 0 and at most 1")
+	}
+
+	if *expensiveMetricSample < 1 && len(*stateFile) < 1 {
+		return errors.New("The -expensiveMetricSample option needs a -stateFile to rotate through the instances")
+	}
+
+	if *failuresToAlert > 1 && len(*stateFile) < 1 {
+		return errors.New("The -failuresToAlert option needs a -stateFile to count failures between runs")
+	}
+
+	if len(*webhookURL) > 0 && len(*stateFile) < 1 {
+		return errors.New("The -webhook option needs a -stateFile to detect transitions")
+	}
+
+	return nil
+}
+
 func main() {
+	setup()
+
+	startCPUProfile()
+	defer stopCPUProfile()
+
+	// Skip this run rather than pile onto one that's still going
+	if len(*lockFile) > 0 {
+		release, ok, err := acquireLock(*lockFile)
+		if err != nil {
+			fatalf("Could not lock %v: %v", *lockFile, err)
+		}
+		if !ok {
+			logger.Info("Another run holds ", *lockFile, ", skipping this one")
+			return
+		}
+		defer release()
+	}
+
+	if len(*discoverPattern) > 0 {
+		discover()
+		return
+	}
+
+	if len(*queryMbean) > 0 {
+		query()
+		return
+	}
+
+	flushOnSignal()
+	sleepSplay()
+
+	if *interval > 0 {
+		if *prewarm > 0 {
+			prewarmConnections()
+		}
+		runEvery(runChecks)
+		return
+	}
+	if err := runChecks(); err != nil {
+		fatalf("%v", err)
+	}
+	flushKafka()
+}
+
+// runChecks is one run: fetch the instances, check them and report the results. An error
+// means the run was given up, a one-off run exits on it and -interval tries again next time.
+func runChecks() error {
+	startReport()
+	resetPartial()
+	resetBreakers(time.Now())
+	runStart := time.Now()
 	logger.Debug("Auto-detected IPs on this server")
-	instances := getInstancesFromPortal()
+	instances, err := getInstancesFromPortal()
+	if err != nil {
+		return err
+	}
+	instances, truncated, err := capInstances(instances)
+	if err != nil {
+		return err
+	}
+	skipped := skippedResults(truncated, "over -maxInstances")
+
+	if tunnelsConfigured() {
+		registerTunnels(instances)
+	}
+
+	var state *RunState
+	if len(*stateFile) > 0 {
+		state = loadState()
+		if err := state.checkInstanceDrop(len(instances)); err != nil {
+			return err
+		}
+		state.Runs++
+		expensiveRun = state.Runs
+	}
+
+	// Instances left out of this run keep their state for the next one
+	var unchecked []TomcatInstance
+
+	if *sampleProjects {
+		sampled, sampledOut := sampleByProject(instances)
+		logger.Infof("Sampling one instance per project: checking %v, skipped %v", len(sampled), len(sampledOut))
+		instances = sampled
+		unchecked = append(unchecked, sampledOut...)
+		skipped = append(skipped, skippedResults(sampledOut, "sampled out")...)
+	}
+
+	instances, missing := splitCheckable(instances)
+	if len(missing) > 0 {
+		logger.Warningf("Skipping %v instances without a ServerIP or HTTPPort", len(missing))
+		unchecked = append(unchecked, missing...)
+		skipped = append(skipped, skippedResults(missing, "missing address or port")...)
+	}
+
+	// Each datacenter is checked as its own group, with its own concurrency budget, so a Jolokia
+	// outage in one datacenter can't hold up the others
+	groups := groupByDatacenter(instances)
+	groupResponseChannel := make(chan []TomcatCheckResult, len(groups))
+	for _, group := range groups {
+		go func(group []TomcatInstance) {
+			groupResponseChannel <- checkInstances(group)
+		}(group)
+	}
+	var tomcatCheckMapping []TomcatCheckResult
+	for range groups {
+		tomcatCheckMapping = append(tomcatCheckMapping, <-groupResponseChannel...)
+	}
+	tomcatCheckMapping = append(tomcatCheckMapping, skipped...)
+
+	if *jmxNonCritical {
+		tomcatCheckMapping = dropNonCriticalJmxFailures(tomcatCheckMapping)
+	}
+
+	if len(*baselineFile) > 0 {
+		tomcatCheckMapping = append(tomcatCheckMapping, configDrift(instances, tomcatCheckMapping)...)
+	}
+
+	if len(*scoreConfigFile) > 0 {
+		tomcatCheckMapping = append(tomcatCheckMapping, healthScores(instances, tomcatCheckMapping)...)
+	}
+
+	tomcatCheckMapping = append(tomcatCheckMapping, fleetResults(tomcatCheckMapping)...)
+
+	// Always report a heartbeat so the portal can tell "everything is down" from "the cron didn't run"
+	tomcatCheckMapping = append(tomcatCheckMapping, heartbeatResult())
+
+	// Remember which instances were up and alert on the ones that changed since the last run
+	if state != nil {
+		transitions := state.update(instances, unchecked, tomcatCheckMapping)
+		tomcatCheckMapping = state.gcOverhead(instances, tomcatCheckMapping, time.Now())
+		tomcatCheckMapping = state.detectRestarts(instances, tomcatCheckMapping)
+		tomcatCheckMapping = state.dampFailures(tomcatCheckMapping)
+		if len(*webhookURL) > 0 {
+			notifyTransitions(transitions)
+		}
+		state.save()
+	}
+
+	// Goroutines finish in any order, sort so two runs of the same fleet can be diffed
+	sortResults(tomcatCheckMapping)
+
+	// Optionally push the numeric results to a Prometheus Pushgateway
+	if len(*pushgatewayURL) > 0 {
+		pushToGateway(tomcatCheckMapping)
+	}
+
+	if len(*influxURL) > 0 {
+		writeToInflux(tomcatCheckMapping)
+	}
+
+	if len(*graphiteAddr) > 0 {
+		writeToGraphite(tomcatCheckMapping)
+	}
+
+	if len(*kafkaBrokers) > 0 {
+		publishToKafka(tomcatCheckMapping)
+	}
+
+	if len(*unixSocket) > 0 {
+		writeToUnixSocket(tomcatCheckMapping)
+	}
+
+	finishNDJSON(tomcatCheckMapping)
+	recordResults(instances, tomcatCheckMapping)
+
+	// Send the info back to admin portal
+	startReporting()
+	err = sendFiltered(tomcatCheckMapping)
+	doneReporting()
+	if err != nil {
+		return err
+	}
+	logger.Debug("Final result:", redacted(tomcatCheckMapping))
+	writeHeapProfile()
+	writeReport()
+
+	if *summary {
+		logSummary(instances, tomcatCheckMapping, time.Since(runStart))
+	}
+	return nil
+}
+
+// sortResults orders results by ServerID then DataType, keeping the order of results that tie
+func sortResults(results []TomcatCheckResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].ServerID != results[j].ServerID {
+			return results[i].ServerID < results[j].ServerID
+		}
+		return results[i].DataType < results[j].DataType
+	})
+}
 
-	// This is the channel the simple HTTP check responses will come back on
-	httpResponseChannel := make(chan []TomcatCheckResult, 8)
+// heartbeatResult tells the portal the cron ran, even when every instance is down
+func heartbeatResult() TomcatCheckResult {
+	heartbeat := strconv.FormatInt(time.Now().Unix(), 10)
+	return TomcatCheckResult{ServerID: "_monitor", ServerStatus: true, DataType: "heartbeat", ServerResponse: heartbeat, Env: *environment}
+}
+
+// checkInstances runs the probes of a group of instances, in -probeOrder
+func checkInstances(instances []TomcatInstance) []TomcatCheckResult {
+	var tomcatCheckMapping []TomcatCheckResult
+
+	remaining := instances
+	for _, probe := range probeOrder() {
+		var results []TomcatCheckResult
+		switch probe {
+		case "tcp":
+			results = checkTCP(remaining)
+		case "http":
+			results = checkHTTP(remaining)
+		case "jmx":
+			results = checkJMX(remaining)
+		}
+		tomcatCheckMapping = append(tomcatCheckMapping, results...)
+
+		if *earlyExitOnFail {
+			var failed []TomcatCheckResult
+			remaining, failed = dropFailedInstances(remaining, results, probe)
+			tomcatCheckMapping = append(tomcatCheckMapping, failed...)
+		}
+	}
+
+	if *forceGC {
+		tomcatCheckMapping = append(tomcatCheckMapping, eachJmx(remaining, forceGCConcurrency(), forceGarbageCollection)...)
+	}
+
+	return tomcatCheckMapping
+}
+
+// checkHTTP times the page of every instance
+func checkHTTP(instances []TomcatInstance) []TomcatCheckResult {
+	// This is the channel the simple HTTP check responses will come back on. Every channel has
+	// room for all the instances so a worker never blocks on its send while holding a
+	// concurrency slot.
+	httpResponseChannel := make(chan []TomcatCheckResult, len(instances))
 
 	rate := time.Second / 10
 	throttle := time.Tick(rate)
@@ -112,33 +589,47 @@ func main() {
 			urlToTest += "portal/xlogin"
 		}
 
-		go getHTTPResponseTime(httpResponseChannel, TomcatInstance, urlToTest)
+		httpTimeout, _ := timeoutsFor(TomcatInstance)
+		go getHTTPResponseTime(httpResponseChannel, TomcatInstance, urlToTest, httpTimeout)
 	}
 
 	// Wait for all the goroutines to finish, collecting the responses
-	tomcatCheckMapping := waitForDomains(httpResponseChannel, len(instances))
+	return waitForDomains(httpResponseChannel, len(instances))
+}
 
+// checkJMX reads the JMX metrics of every instance
+func checkJMX(instances []TomcatInstance) []TomcatCheckResult {
+	return eachJmx(instances, *jmxConcurrency, getJmxAttributes)
+}
+
+// eachJmx runs a Jolokia worker for every instance with at most limit running at once, 0 for no
+// limit, and collects their results
+func eachJmx(instances []TomcatInstance, limit int, worker func(chan []TomcatCheckResult, TomcatInstance, time.Duration)) []TomcatCheckResult {
 	// This is the channel the JMX responses from Jolokia will come back on
-	jmxResponseChannel := make(chan []TomcatCheckResult, 8)
+	jmxResponseChannel := make(chan []TomcatCheckResult, len(instances))
 
-	for _, TomcatInstance := range instances {
-		// TODO: make this concurrent
-		go getJmxAttributes(jmxResponseChannel, TomcatInstance)
+	// Every JMX read goes through the Jolokia proxy, so it gets its own limit separate from the HTTP checks
+	var jmxSlots chan struct{}
+	if limit > 0 {
+		jmxSlots = make(chan struct{}, limit)
 	}
 
-	// Wait for all the goroutines to finish, collecting the responses
-	jmxCheckMapping := waitForDomains(jmxResponseChannel, len(instances))
-
-	// Append all results together
-	tomcatCheckMapping = append(tomcatCheckMapping, jmxCheckMapping...)
+	for _, instance := range instances {
+		_, jmxTimeout := timeoutsFor(instance)
+		go func(tomcat TomcatInstance, timeout time.Duration) {
+			if jmxSlots != nil {
+				jmxSlots <- struct{}{}
+				defer func() { <-jmxSlots }()
+			}
+			worker(jmxResponseChannel, tomcat, timeout)
+		}(instance, jmxTimeout)
+	}
 
-	// Send the info back to admin portal
-	updateAdminPortal(tomcatCheckMapping)
-	logger.Debug("Final result:", tomcatCheckMapping)
+	// Wait for all the goroutines to finish, collecting the responses
+	return waitForDomains(jmxResponseChannel, len(instances))
 }
 
-func getInstancesFromPortal() []TomcatInstance {
-	var tomcatInstances []TomcatInstance
+func getInstancesFromPortal() ([]TomcatInstance, error) {
 	url := adminURL + "?1=1"
 	if len(*localIP) > 1 {
 		url += "&ips=" + *localIP
@@ -147,73 +638,309 @@ func getInstancesFromPortal() []TomcatInstance {
 		url += "&clientID=" + *clientID
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Set("X-Auth-Token", *token)
-	req.Header.Set("Content-Type", "text/plain")
-	req.Header.Set("User-Agent", cronUserAgent)
+	// The portal briefly answers 200 with an empty list while it reindexes, so retry a few times
+	// before believing there really are no instances
+	tomcatInstances, err := fetchInstances(url)
+	for attempt := 1; err == nil && len(tomcatInstances) == 0 && attempt <= *emptyRetries; attempt++ {
+		backoff := time.Duration(attempt) * 2 * time.Second
+		logger.Warningf("Admin portal returned no instances, retry %v of %v in %v", attempt, *emptyRetries, backoff)
+		time.Sleep(backoff)
+		tomcatInstances, err = fetchInstances(url)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	if len(tomcatInstances) == 0 && *emptyRetries > 0 {
+		logger.Warning("Admin portal still returned no instances, accepting an empty list")
+	}
+
+	return tomcatInstances, nil
+}
+
+// capInstances guards against a bad -ips filter or portal bug handing us so many instances that
+// checking them all would swamp Jolokia
+func capInstances(instances []TomcatInstance) (kept []TomcatInstance, dropped []TomcatInstance, err error) {
+	if *maxInstances < 1 || len(instances) <= *maxInstances {
+		return instances, nil, nil
+	}
+
+	if !*truncateInstances {
+		return nil, nil, fmt.Errorf("Admin portal returned %v instances, more than -maxInstances %v", len(instances), *maxInstances)
+	}
+	logger.Errorf("Admin portal returned %v instances, only checking the first %v (-maxInstances)", len(instances), *maxInstances)
+	recordError("truncated %v instances to %v", len(instances), *maxInstances)
+	return instances[:*maxInstances], instances[*maxInstances:], nil
+}
+
+func fetchInstances(url string) ([]TomcatInstance, error) {
+	var tomcatInstances []TomcatInstance
+
+	resp, err := doPortal(func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := setPortalAuth(req); err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("User-Agent", cronUserAgent)
+		return req, nil
+	})
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("Could not fetch instances from admin portal: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
+		body, err := readBody(resp)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read admin portal response: %v", err)
+		}
 
 		// We have real info
 		if len(body) > 5 {
-			json.Unmarshal(body, &tomcatInstances)
-			logger.Debug("Raw data from admin portal: ", tomcatInstances)
+			if err := json.Unmarshal(body, &tomcatInstances); err != nil {
+				return nil, fmt.Errorf("Could not parse instances from %v: %v: %v", redactLog(url), err, redactLog(bodySnippet(body)))
+			}
+			logger.Debug("Raw data from admin portal: ", redacted(tomcatInstances))
 		}
 	} else {
-		logger.Alertf("Bad HTTP fetch: %v \n", resp.Status)
-		os.Exit(1)
+		return nil, fmt.Errorf("Bad HTTP fetch: %v", resp.Status)
+	}
+
+	return tomcatInstances, nil
+}
+
+// bodySnippet is the start of a response body, short enough to put in an error
+func bodySnippet(body []byte) string {
+	const max = 200
+	if len(body) <= max {
+		return string(body)
 	}
+	return string(body[:max]) + "..."
+}
 
-	return tomcatInstances
+// readBody reads a response body, decompressing it when the server gzipped it without the
+// transport asking for it (Go only decodes transparently when it set Accept-Encoding itself)
+func readBody(resp *http.Response) ([]byte, error) {
+	var reader io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	return ioutil.ReadAll(reader)
 }
 
-func getHTTPResponseTime(returnChannel chan []TomcatCheckResult, tomcat TomcatInstance, urlToTest string) {
+func getHTTPResponseTime(returnChannel chan []TomcatCheckResult, tomcat TomcatInstance, urlToTest string, timeout time.Duration) {
+	tracker := &reuseTracker{transport: traced(checkTransport)}
+	routes := &routeTracker{transport: tracker}
 	client := http.Client{
-		Timeout: time.Duration(5 * time.Second),
+		Transport: routes,
+		Timeout:   timeout,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
 
-	timeStart := time.Now()
-	resp, err := client.Get(urlToTest)
-	httpOK := false
-	requestTime := "0"
+	method := strings.ToUpper(tomcat.Method)
+	if method != "HEAD" {
+		method = "GET"
+	}
 
-	if err != nil {
-		logger.Debugf("Error fetching: %v", err)
+	expect := expectationFor(tomcat)
+	var tomcatCheckArray []TomcatCheckResult
+	var timeResult TomcatCheckResult
+
+	if len(tomcat.CheckURLs) == 0 {
+		httpOK, requestTime, failureReason := checkURL(&client, method, urlToTest, expect)
+		timeResult = newCheckResult(tomcat, httpOK, "time", requestTime)
+		timeResult.FailureReason = failureReason
+		tomcatCheckArray = append(tomcatCheckArray, timeResult)
 	} else {
-		defer resp.Body.Close()
+		// Each URL gets its own time_<index> result, the overall time result is only up when
+		// every URL passed and reports the slowest one
+		allOK := true
+		slowest := "0"
+		failureReason := ""
+		for i, checkPath := range tomcat.CheckURLs {
+			fullURL := checkPath
+			if strings.HasPrefix(checkPath, "/") {
+				fullURL = "http://" + tomcat.ServerIP + ":" + tomcat.HTTPPort + checkPath
+			}
 
-		requestTime = strconv.FormatInt(time.Since(timeStart).Nanoseconds()/1000, 10)
-		logger.Debug("Request time:", urlToTest, requestTime, resp.StatusCode)
-		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusFound {
-			httpOK = true
+			httpOK, requestTime, reason := checkURL(&client, method, fullURL, expect)
+			urlResult := newCheckResult(tomcat, httpOK, "time_"+strconv.Itoa(i), requestTime)
+			urlResult.FailureReason = reason
+			tomcatCheckArray = append(tomcatCheckArray, urlResult)
+
+			if !httpOK && allOK {
+				allOK = false
+				failureReason = fullURL + ": " + reason
+			}
+			if slower(requestTime, slowest) {
+				slowest = requestTime
+			}
 		}
+
+		timeResult = newCheckResult(tomcat, allOK, "time", slowest)
+		timeResult.FailureReason = failureReason
+		tomcatCheckArray = append(tomcatCheckArray, timeResult)
 	}
 
-	var tomcatCheckArray []TomcatCheckResult
-	tomcatCheckArray = append(tomcatCheckArray, TomcatCheckResult{tomcat.ServerID, httpOK, "time", requestTime})
+	if elapsed, err := parseElapsed(timeResult.ServerResponse); err == nil && timeResult.ServerStatus && isDegraded(elapsed, timeout) {
+		tomcatCheckArray = append(tomcatCheckArray, degradedResult(tomcat, "degraded"))
+	}
+
+	if reused, ok := tracker.result(tomcat); ok {
+		tomcatCheckArray = append(tomcatCheckArray, reused)
+	}
+
+	if mismatch, ok := routes.result(tomcat); ok {
+		tomcatCheckArray = append(tomcatCheckArray, mismatch)
+	}
 
 	// Send our results back to the main processes via our return channel
 	returnChannel <- tomcatCheckArray
 }
 
+// checkURL probes a URL, confirming a failure with a second probe when -confirmDown is set
+func checkURL(client *http.Client, method string, urlToTest string, expect httpExpectation) (httpOK bool, requestTime string, failureReason string) {
+	if *warmup {
+		warmUp(client, method, urlToTest)
+	}
+	httpOK, requestTime, failureReason = probeHTTP(client, method, urlToTest, expect)
+
+	// Rule out a transient error with a second probe before reporting the instance down
+	if !httpOK && *confirmDown {
+		confirmOK, confirmTime, confirmReason := probeHTTP(client, method, confirmURL(urlToTest), expect)
+		if confirmOK {
+			logger.Debug("Confirm probe passed after a failed check", redactLog(urlToTest), failureReason)
+			httpOK, requestTime = true, confirmTime
+			failureReason = "initial probe failed: " + failureReason
+		} else {
+			failureReason = "initial probe failed: " + failureReason + "; confirm probe failed: " + confirmReason
+		}
+	}
+	return
+}
+
+// warmUp sends a request whose result is thrown away, so lazily initialized servlets are
+// loaded before the timed probe. Its failures are left for the timed probe to report.
+func warmUp(client *http.Client, method string, urlToTest string) {
+	req, err := http.NewRequest(method, urlToTest, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", cronUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Debugf("Warmup request to %v failed: %v", redactLog(urlToTest), redacted(err))
+		return
+	}
+	drainBody(resp.Body)
+}
+
+// slower compares two formatted response times
+func slower(a string, b string) bool {
+	x, errA := strconv.ParseInt(a, 10, 64)
+	y, errB := strconv.ParseInt(b, 10, 64)
+	return errA == nil && errB == nil && x > y
+}
+
+// probeHTTP makes one timed check request
+func probeHTTP(client *http.Client, method string, urlToTest string, expect httpExpectation) (httpOK bool, requestTime string, failureReason string) {
+	requestTime = "0"
+
+	req, err := http.NewRequest(method, urlToTest, nil)
+	if err != nil {
+		logger.Debugf("Bad check URL %v: %v", redactLog(urlToTest), redacted(err))
+		return false, requestTime, "bad url"
+	}
+	req.Header.Set("User-Agent", cronUserAgent)
+
+	timeStart := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Debugf("Error fetching: %v", redacted(err))
+		return false, requestTime, classifyHTTPError(err)
+	}
+	defer drainBody(resp.Body)
+
+	requestTime = formatElapsed(time.Since(timeStart))
+	logger.Debug("Request time:", redactLog(urlToTest), requestTime, resp.StatusCode)
+	if reason := expect.check(resp); reason != "" {
+		return false, requestTime, reason
+	}
+	return true, requestTime, ""
+}
+
+// drainBody reads what's left of a check response, up to -maxBodyBytes, before closing it so
+// the keep-alive connection can be reused. Bodies bigger than that aren't worth pulling down,
+// their connection is simply closed.
+func drainBody(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, io.LimitReader(body, *maxBodyBytes))
+	body.Close()
+}
+
+// confirmURL is where the confirm probe goes, the checked URL with -confirmPath and
+// -confirmPort swapped in when they're set
+func confirmURL(urlToTest string) string {
+	if *confirmPath == "" && *confirmPort == "" {
+		return urlToTest
+	}
+
+	u, err := url.Parse(urlToTest)
+	if err != nil {
+		return urlToTest
+	}
+	if *confirmPath != "" {
+		u.Path = *confirmPath
+		u.RawQuery = ""
+	}
+	if *confirmPort != "" {
+		u.Host = net.JoinHostPort(u.Hostname(), *confirmPort)
+	}
+	return u.String()
+}
+
+// classifyHTTPError turns a transport error into a short reason for alerts
+func classifyHTTPError(err error) string {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return "timeout"
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "connection refused"):
+		return "connection refused"
+	case strings.Contains(msg, "no such host"):
+		return "dns failure"
+	case strings.Contains(msg, "connection reset"):
+		return "connection reset"
+	}
+	return msg
+}
+
 // The extra set of parentheses here are the return type. You can give the return value a name,
 // in this case +tomcatCheckMapping+ and use that name in the function body. Then you don't need to specify
 // what actually gets returned, you've already defined it here.
 func waitForDomains(responseChannel chan []TomcatCheckResult, instanceCount int) (tomcatCheckMapping []TomcatCheckResult) {
+	if instanceCount < 1 {
+		return
+	}
+
 	returnedCount := 0
 	for {
-		tomcatCheckMapping = append(tomcatCheckMapping, <-responseChannel...)
+		results := <-responseChannel
+		collectPartial(results)
+		streamNDJSON(results)
+		tomcatCheckMapping = append(tomcatCheckMapping, results...)
 		returnedCount++
 
 		if returnedCount >= instanceCount {
@@ -224,157 +951,436 @@ func waitForDomains(responseChannel chan []TomcatCheckResult, instanceCount int)
 	return
 }
 
-func getJmxAttributes(returnChannel chan []TomcatCheckResult, tomcat TomcatInstance) {
+func getJmxAttributes(returnChannel chan []TomcatCheckResult, tomcat TomcatInstance, timeout time.Duration) {
+	ports := tomcat.JmxPorts
+	// An agent on the instance only sees its own JVM
+	if len(ports) == 0 || len(tomcat.JolokiaURL) > 0 {
+		returnChannel <- readJmx(tomcat, timeout)
+		return
+	}
+
+	// The first port is the platform JVM and reports plain DataTypes, the others get their port
+	// appended so the results don't overwrite each other under the same ServerID
+	var multipleTomcatResults []TomcatCheckResult
+	for i, port := range ports {
+		portTomcat := tomcat
+		portTomcat.JmxPort = port
+		results := readJmx(portTomcat, timeout)
+		if i > 0 {
+			for j := range results {
+				results[j].DataType += "_port" + port
+			}
+		}
+		multipleTomcatResults = append(multipleTomcatResults, results...)
+	}
+	returnChannel <- multipleTomcatResults
+}
+
+// readJmx reads the metrics of the JVM behind tomcat.JmxPort, or its own Jolokia agent
+func readJmx(tomcat TomcatInstance, timeout time.Duration) []TomcatCheckResult {
 	var multipleTomcatResults []TomcatCheckResult
 
-	// Constract the target for Jolokia
-	jmxURL := "service:jmx:rmi:///jndi/rmi://" + tomcat.ServerIP + ":" + tomcat.JmxPort + "/jmxrmi"
-
-	heapRequest := JolokiaRequest{
-		Type:      "READ",
-		Mbean:     "java.lang:type=Memory",
-		Attribute: "HeapMemoryUsage",
-		Path:      "used",
-		Target: struct {
-			URL string `json:"url"`
-		}{URL: jmxURL},
+	endpoint, jmxURL := jolokiaEndpoint(tomcat)
+	now := time.Now()
+
+	expensive := readsExpensive(tomcat)
+
+	// Static metrics still cached from an earlier run aren't read again
+	var cached []TomcatCheckResult
+	var requests []JolokiaRequest
+	for _, metric := range jmxMetrics {
+		if !metric.appliesTo(tomcat) || (metric.expensive() && !expensive) {
+			continue
+		}
+		if results, ok := cachedStatic(tomcat, metric, now); ok {
+			cached = append(cached, results...)
+			continue
+		}
+		requests = append(requests, metric.request(jmxURL))
+	}
+	if len(requests) == 0 {
+		return cached
 	}
 
-	threadRequest := JolokiaRequest{
-		Type:      "READ",
-		Mbean:     "java.lang:type=Threading",
-		Attribute: "ThreadCount",
-		Target: struct {
-			URL string `json:"url"`
-		}{URL: jmxURL},
+	if breakerOpen(endpoint) {
+		return []TomcatCheckResult{circuitOpenResult(tomcat, endpoint)}
 	}
 
-	cpuRequest := JolokiaRequest{
-		Type:      "READ",
-		Mbean:     "java.lang:type=OperatingSystem",
-		Attribute: "ProcessCpuTime",
-		Target: struct {
-			URL string `json:"url"`
-		}{URL: jmxURL},
+	start := time.Now()
+	respJ, err := postJolokia(endpoint, requests, timeout)
+	// Only failures to talk to Jolokia itself count, a JVM behind it being down doesn't
+	_, unreachable := err.(net.Error)
+	recordEndpoint(endpoint, !unreachable)
+	if err == errResponseTooLarge {
+		logger.Errorf("Jolokia response for %v is larger than %v bytes, skipping", tomcat.ServerID, *maxJmxBody)
+		recordError("jmx %v: response larger than %v bytes", tomcat.ServerID, *maxJmxBody)
+		return []TomcatCheckResult{jmxFailure(tomcat, "response too large", fmt.Sprintf("response larger than %v bytes", *maxJmxBody))}
+	} else if err != nil {
+		logger.Debug("Bad jolokia response", redacted(err))
+		recordError("jmx %v: %v", tomcat.ServerID, err)
+		// Jolokia itself couldn't be reached, says nothing about the JVM
+		if _, ok := err.(net.Error); ok {
+			return []TomcatCheckResult{jmxFailure(tomcat, "jolokia_down", err.Error())}
+		}
+		return []TomcatCheckResult{jmxFailure(tomcat, "bad jolokia response", err.Error())}
 	}
 
-	sakaiSessionRequest := JolokiaRequest{
-		Type:      "READ",
-		Mbean:     "org.sakaiproject:name=Sessions",
-		Attribute: "Active15Min",
-		Target: struct {
-			URL string `json:"url"`
-		}{URL: jmxURL},
+	// The proxy answered but every read failed: the JMX target behind it is what's down
+	if jmxURL != "" && allReadsFailed(respJ) {
+		logger.Debug("No jolokia read succeeded for ", tomcat.ServerID, ": ", respJ[0].Error)
+		recordError("jmx %v: target unreachable: %v", tomcat.ServerID, respJ[0].Error)
+		return []TomcatCheckResult{jmxFailure(tomcat, "jmx_unreachable", respJ[0].Error)}
 	}
 
-	hikariRequest := JolokiaRequest{
-		Type:      "READ",
-		Mbean:     "com.zaxxer.hikari:type=Pool (sakai)",
-		Attribute: "ActiveConnections",
-		Target: struct {
-			URL string `json:"url"`
-		}{URL: jmxURL},
+	// This is our decoded response from jolokia
+	jResponse := &respJ
+
+	// Every response in the bulk reply carries the same timestamp, one is enough. Through the
+	// proxy it's the proxy's clock, only an agent on the instance tells us about the instance.
+	if len(respJ) > 0 && jmxURL == "" {
+		if skew, ok := clockSkewResult(tomcat, respJ[0].Timestamp, time.Now()); ok {
+			multipleTomcatResults = append(multipleTomcatResults, skew)
+		}
 	}
 
-	garbageRequest := JolokiaRequest{
-		Type:      "READ",
-		Mbean:     "java.lang:name=ConcurrentMarkSweep,type=GarbageCollector",
-		Attribute: "CollectionTime",
-		Target: struct {
-			URL string `json:"url"`
-		}{URL: jmxURL},
+	var counter int
+	for _, jResp := range *jResponse {
+		mbean := string(jResp.Request.Mbean)
+
+		// Reads the JVM doesn't support come back with an error and no value, skip them
+		if jResp.Status != http.StatusOK || jResp.Value == nil {
+			logger.Debug("Skipping failed jolokia read: ", mbean, jResp.Request.Attribute, jResp.Error)
+			continue
+		}
+
+		for _, metric := range jmxMetrics {
+			if metric.matches(mbean, jResp.Request.Attribute, jResp.Request.Path, jResp.Request.Operation) {
+				metricResults := metric.results(tomcat, jResp.Value)
+				cacheStatic(tomcat, metric, metricResults, now)
+				multipleTomcatResults = append(multipleTomcatResults, metricResults...)
+				break
+			}
+		}
+		logger.Debug("response value: ", mbean, jResp.Value)
+		counter++
 	}
 
-	var requestArray [6]JolokiaRequest
-	requestArray[0] = heapRequest
-	requestArray[1] = threadRequest
-	requestArray[2] = cpuRequest
-	requestArray[3] = sakaiSessionRequest
-	requestArray[4] = hikariRequest
-	requestArray[5] = garbageRequest
+	multipleTomcatResults = append(multipleTomcatResults, connectionUtilization(tomcat, multipleTomcatResults)...)
+	multipleTomcatResults = append(multipleTomcatResults, cacheHitRatio(tomcat, multipleTomcatResults)...)
+	multipleTomcatResults = append(multipleTomcatResults, sessionUtilization(tomcat, multipleTomcatResults)...)
+
+	if isDegraded(time.Since(start), timeout) {
+		multipleTomcatResults = append(multipleTomcatResults, degradedResult(tomcat, "jmx_degraded"))
+	}
+	return append(multipleTomcatResults, cached...)
+}
 
-	jsonRequest, err := json.Marshal(requestArray)
+// jmxFailure is the failed "jmx" result reported when an instance's metrics couldn't be read,
+// so an attempted read always shows up. The detail, usually the error message, is the response.
+func jmxFailure(tomcat TomcatInstance, reason string, detail string) TomcatCheckResult {
+	failed := newCheckResult(tomcat, false, "jmx", detail)
+	failed.FailureReason = reason
+	return failed
+}
+
+// allReadsFailed tells whether a bulk response has no successful read at all
+func allReadsFailed(respJ JolokiaRequestResponse) bool {
+	if len(respJ) == 0 {
+		return false
+	}
+	for _, jResp := range respJ {
+		if jResp.Status == http.StatusOK {
+			return false
+		}
+	}
+	return true
+}
+
+// jolokiaEndpoint returns where to POST the Jolokia requests of an instance and the JMX
+// service URL to target. Instances with their own agent are read directly without a target,
+// the rest go through the -jolokia proxy.
+func jolokiaEndpoint(tomcat TomcatInstance) (endpoint string, jmxURL string) {
+	if len(tomcat.JolokiaURL) > 0 {
+		return tomcat.JolokiaURL, ""
+	}
+
+	// Constract the target for Jolokia
+	return *jolokiaURL, "service:jmx:rmi:///jndi/rmi://" + tomcat.ServerIP + ":" + tomcat.JmxPort + "/jmxrmi"
+}
+
+var errResponseTooLarge = errors.New("response too large")
+
+// postJolokia sends a bulk request to Jolokia and decodes the responses, which come back in
+// the same order as the requests
+func postJolokia(endpoint string, requests []JolokiaRequest, timeout time.Duration) (JolokiaRequestResponse, error) {
+	var respJ JolokiaRequestResponse
+
+	jsonRequest, err := json.Marshal(requests)
 	if err != nil {
 		panic("Could not marshal json for jolokia request")
 	}
-	logger.Debug("json: " + string(jsonRequest))
+	logger.Debug("json: " + redactLog(string(jsonRequest)))
+	major := jolokiaMajorVersion(endpoint, timeout)
 
 	client := &http.Client{
-		Timeout: time.Duration(time.Duration(*jolokiaTimeout) * time.Second),
+		Transport: traced(jolokiaTransport),
+		Timeout:   timeout,
 	}
-	req, _ := http.NewRequest("POST", *jolokiaURL, strings.NewReader(string(jsonRequest)))
+	req, _ := http.NewRequest("POST", endpoint, strings.NewReader(string(jsonRequest)))
 	req.Header.Set("User-Agent", cronUserAgent)
-	resp, respErr := client.Do(req)
-
-	if respErr != nil {
-		logger.Debug("Bad jolokia response", respErr)
-		returnChannel <- multipleTomcatResults
-		return
+	jolokiaHeaders.apply(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return respJ, err
 	}
 	defer resp.Body.Close()
 
+	// Read one byte past the cap so an oversized body can be told apart from one that's exactly the cap
+	contents, err := ioutil.ReadAll(io.LimitReader(resp.Body, *maxJmxBody+1))
+	if err != nil {
+		return respJ, err
+	}
+	if int64(len(contents)) > *maxJmxBody {
+		return respJ, errResponseTooLarge
+	}
+
+	respJ, err = decodeJolokiaResponse(contents, major)
+	if err != nil {
+		logger.Error("Bad jolokia decode", err)
+		logger.Debug("Raw jolokia body: ", redactLog(string(contents)))
+	}
+	return respJ, err
+}
+
+// decodeJolokiaBody decodes a bulk Jolokia response, insisting on exactly one JSON array so
+// proxies that wrap or append to the body are caught instead of half decoded
+func decodeJolokiaBody(contents []byte) (JolokiaRequestResponse, error) {
 	var respJ JolokiaRequestResponse
-	dec := json.NewDecoder(resp.Body)
+	err := decodeJolokiaArray(contents, &respJ)
+	return respJ, err
+}
 
-	//contents, _ := ioutil.ReadAll(resp.Body)
-	//logger.Debug("Raw body:", string(contents), dec)
+// decodeJolokiaArray decodes exactly one JSON array into responses, whichever struct they are
+func decodeJolokiaArray(contents []byte, responses interface{}) error {
+	trimmed := bytes.TrimSpace(contents)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return errors.New("jolokia response is not a JSON array")
+	}
 
-	if err := dec.Decode(&respJ); err != nil {
-		logger.Error("Bad jolokia decode", err)
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	// Keep large counters like ProcessCpuTime exact instead of going through float64
+	dec.UseNumber()
+
+	if err := dec.Decode(responses); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after the jolokia response")
 	}
+	return nil
+}
 
-	// This is our decoded response from jolokia
-	jResponse := &respJ
+// formatJolokiaValue renders a numeric Jolokia value as a string for the admin portal
+func formatJolokiaValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String(), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	return "", false
+}
 
-	var counter int
-	for _, jResp := range *jResponse {
-		mbean := string(jResp.Request.Mbean)
-		v := strconv.FormatInt(jResp.Value, 10)
-
-		if mbean == "java.lang:type=Memory" {
-			multipleTomcatResults = append(multipleTomcatResults, TomcatCheckResult{tomcat.ServerID, true, "memory", v})
-		} else if mbean == "java.lang:type=Threading" {
-			multipleTomcatResults = append(multipleTomcatResults, TomcatCheckResult{tomcat.ServerID, true, "threads", v})
-		} else if mbean == "java.lang:type=OperatingSystem" {
-			multipleTomcatResults = append(multipleTomcatResults, TomcatCheckResult{tomcat.ServerID, true, "cpu", v})
-		} else if mbean == "org.sakaiproject:name=Sessions" {
-			multipleTomcatResults = append(multipleTomcatResults, TomcatCheckResult{tomcat.ServerID, true, "sessions", v})
-		} else if mbean == "com.zaxxer.hikari:type=Pool (sakai)" {
-			multipleTomcatResults = append(multipleTomcatResults, TomcatCheckResult{tomcat.ServerID, true, "db", v})
-		} else if mbean == "java.lang:name=ConcurrentMarkSweep,type=GarbageCollector" {
-			multipleTomcatResults = append(multipleTomcatResults, TomcatCheckResult{tomcat.ServerID, true, "gc", v})
-		}
-		logger.Debug("response value: ", mbean, v)
-		counter++
+// loadPercent converts a 0.0-1.0 load value into a percentage. The JVM reports a negative
+// load when it isn't available yet, so those are skipped.
+func loadPercent(value interface{}) (string, bool) {
+	s, ok := formatJolokiaValue(value)
+	if !ok {
+		return "", false
 	}
+	load, err := strconv.ParseFloat(s, 64)
+	if err != nil || load < 0 {
+		return "", false
+	}
+	return strconv.FormatFloat(load*100, 'f', 2, 64), true
+}
 
-	// Send our results back to the main processes via our return channel
-	returnChannel <- multipleTomcatResults
+func updateAdminPortal(tomcatChecks []TomcatCheckResult) error {
+	// The portal keys everything by server id, so batches can be posted in any order
+	batches := batchResults(tomcatChecks, *postBatchSize)
+
+	concurrency := *postConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	errs := make(chan error, len(batches))
+
+	for _, batch := range batches {
+		go func(batch []TomcatCheckResult) {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			errs <- postResults(batch)
+		}(batch)
+	}
+
+	failed := 0
+	for range batches {
+		if err := <-errs; err != nil {
+			logger.Error("Could not POST batch", err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Could not POST update: %v of %v batches failed", failed, len(batches))
+	}
+	return nil
 }
 
-func updateAdminPortal(tomcatChecks []TomcatCheckResult) {
-	jsonData, err := json.Marshal(tomcatChecks)
-	if err != nil {
-		panic(err)
+// batchResults splits the results into batches of at most size, size 0 means one batch
+func batchResults(results []TomcatCheckResult, size int) [][]TomcatCheckResult {
+	if size < 1 || len(results) <= size {
+		return [][]TomcatCheckResult{results}
 	}
 
+	var batches [][]TomcatCheckResult
+	for start := 0; start < len(results); start += size {
+		end := start + size
+		if end > len(results) {
+			end = len(results)
+		}
+		batches = append(batches, results[start:end])
+	}
+	return batches
+}
+
+// postResults sends one batch of results to the admin portal
+func postResults(tomcatChecks []TomcatCheckResult) error {
 	// Unix time converted to a string
 	//currentTime := strconv.FormatInt(time.Now().Unix(), 10)
 
 	postURL := "https://admin.longsight.com/longsight/go/healthinfo"
 	//urlValues := url.Values{"time": {string(currentTime)}, "data": {string(jsonData)}}
-	logger.Debug("Values being sent to admin portal: ", string(jsonData))
 
-	client := &http.Client{}
-	req, _ := http.NewRequest("POST", postURL, strings.NewReader(string(jsonData)))
-	req.Header.Set("X-Auth-Token", *token)
-	req.Header.Set("Content-Type", "text/plain")
-	req.Header.Set("User-Agent", cronUserAgent)
-	resp, err := client.Do(req)
+	var newBody func() io.Reader
+	var contentLength int64
+	var compressed bool
+	if streamable() {
+		// Encoded as it's sent, so a huge batch is never held in memory as JSON
+		size, err := payloadSize(tomcatChecks)
+		if err != nil {
+			return err
+		}
+		logger.Debugf("Streaming %v results, %v bytes, to admin portal", len(tomcatChecks), size)
+		logger.Debug("Values being sent to admin portal: ", loggedPayload(tomcatChecks))
 
-	logger.Debug("Response from admin portal: ", resp)
+		// Older portals choke on Content-Encoding, and small payloads aren't worth compressing
+		compressed = *portalAcceptsGzip && size >= int64(*gzipThreshold)
+		newBody = func() io.Reader { return streamPayload(tomcatChecks, compressed) }
+		if !compressed {
+			contentLength = size
+		}
+	} else {
+		jsonData, err := portalPayload(tomcatChecks)
+		if err != nil {
+			return err
+		}
+		if len(*postProcessCommand) > 0 {
+			jsonData = postProcess(jsonData)
+		}
+		logger.Debug("Values being sent to admin portal: ", redactLog(string(jsonData)))
+
+		body := jsonData
+		compressed = *portalAcceptsGzip && len(jsonData) >= *gzipThreshold
+		if compressed {
+			if body, err = gzipBytes(jsonData); err != nil {
+				return err
+			}
+			logger.Debugf("Gzipped admin portal payload from %v to %v bytes", len(jsonData), len(body))
+		}
+		newBody = func() io.Reader { return bytes.NewReader(body) }
+	}
+
+	resp, err := doPortal(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", postURL, newBody())
+		if err != nil {
+			return nil, err
+		}
+		if contentLength > 0 {
+			req.ContentLength = contentLength
+		}
+		if err := setPortalAuth(req); err != nil {
+			// Nothing will read a streamed body now, closing it stops its encoder
+			req.Body.Close()
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		if compressed {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		req.Header.Set("User-Agent", cronUserAgent)
+		return req, nil
+	})
 
 	if err != nil {
-		panic("Could not POST update")
+		return err
+	}
+	logger.Debug("Response from admin portal: ", resp.Status)
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := readBody(resp)
+		return fmt.Errorf("Admin portal answered %v: %v", resp.Status, redactLog(bodySnippet(body)))
+	}
+
+	if *verifyAck {
+		verifyPortalAck(resp, len(tomcatChecks))
+	}
+	return nil
+}
+
+// gzipBytes compresses a payload in one go
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PortalAck is what the admin portal answers after storing health info
+type PortalAck struct {
+	Accepted *int `json:"accepted"`
+}
+
+// verifyPortalAck warns when the portal stored fewer records than we sent
+func verifyPortalAck(resp *http.Response, sent int) {
+	// An error page has no ack to read, and whatever JSON it carries isn't one
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Warningf("Admin portal answered %v, no ack to check", resp.Status)
+		return
+	}
+
+	body, err := readBody(resp)
+	if err != nil {
+		logger.Warning("Could not read admin portal ack", err)
+		return
+	}
+
+	var ack PortalAck
+	if err := json.Unmarshal(body, &ack); err != nil || ack.Accepted == nil {
+		logger.Warningf("Admin portal did not return an ack (status %v): %v", resp.Status, string(body))
+		return
+	}
+
+	if *ack.Accepted != sent {
+		logger.Warningf("Admin portal accepted %v of %v results, %v were dropped", *ack.Accepted, sent, sent-*ack.Accepted)
+	} else {
+		logger.Debugf("Admin portal accepted all %v results", sent)
 	}
 }
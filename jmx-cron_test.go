@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexcesaro/log/stdlog"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	logger = stdlog.GetFromFlags()
+	checkTransport = newCheckTransport()
+	jolokiaTransport = newJolokiaTransport()
+	os.Exit(m.Run())
+}
+
+func gzipped(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const instancesPayload = `[{"ServerID":"tomcat1","ServerIP":"10.0.0.1","HTTPPort":"8080","JmxPort":"9090","ProjectName":"sakai"}]`
+
+func TestReadBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{"plain", "", []byte(instancesPayload)},
+		{"gzip", "gzip", gzipped(t, instancesPayload)},
+		{"gzip mixed case", "GZip", gzipped(t, instancesPayload)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header: http.Header{},
+				Body:   ioutil.NopCloser(bytes.NewReader(tt.body)),
+			}
+			if tt.encoding != "" {
+				resp.Header.Set("Content-Encoding", tt.encoding)
+			}
+
+			body, err := readBody(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != instancesPayload {
+				t.Errorf("got %q, want %q", body, instancesPayload)
+			}
+		})
+	}
+}
+
+func TestFetchInstancesGzipped(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipped(t, instancesPayload))
+	}))
+	defer server.Close()
+
+	instances, err := fetchInstances(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 || instances[0].ServerID != "tomcat1" || instances[0].HTTPPort != "8080" {
+		t.Errorf("got %+v", instances)
+	}
+}
+
+func TestFetchInstancesBadJSON(t *testing.T) {
+	page := "<html><body>" + strings.Repeat("Scheduled maintenance. ", 20) + "</body></html>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	instances, err := fetchInstances(server.URL)
+	if err == nil {
+		t.Fatalf("got %+v and no error from a portal answering HTML", instances)
+	}
+	if msg := err.Error(); !strings.Contains(msg, server.URL) || !strings.Contains(msg, page[:200]) || strings.Contains(msg, page) {
+		t.Errorf("got %q, want the URL and the start of the body", msg)
+	}
+}
+
+func TestFetchInstancesPortalDown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	// In -interval mode the daemon has to live through this, so it's an error rather than an exit
+	if instances, err := fetchInstances(server.URL); err == nil {
+		t.Errorf("got %+v and no error from a portal answering 502", instances)
+	}
+
+	server.Close()
+	if _, err := fetchInstances(server.URL); err == nil {
+		t.Error("no error from a portal that's gone")
+	}
+}
+
+// portalReplies stands in for the admin portal, answering each request with the next of its
+// statuses and 200 once they run out. It keeps the bodies it was sent.
+type portalReplies struct {
+	sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func (p *portalReplies) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	p.bodies = append(p.bodies, string(body))
+	status := http.StatusOK
+	if len(p.statuses) > 0 {
+		status, p.statuses = p.statuses[0], p.statuses[1:]
+	}
+	reply := `{"accepted": 0}`
+	if status != http.StatusOK {
+		reply = "<html><body>" + http.StatusText(status) + "</body></html>"
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     strconv.Itoa(status) + " " + http.StatusText(status),
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(reply)),
+		Request:    req,
+	}, nil
+}
+
+func TestPostResultsErrorStatus(t *testing.T) {
+	defer func(tok string, transport http.RoundTripper) { *token, portalClient.Transport = tok, transport }(*token, portalClient.Transport)
+	portal := &portalReplies{statuses: []int{http.StatusInternalServerError}}
+	*token, portalClient.Transport = "t0ken", portal
+
+	results := []TomcatCheckResult{{ServerID: "app1", ServerStatus: true, DataType: "time", ServerResponse: "120"}}
+	err := postResults(results)
+	if err == nil || !strings.Contains(err.Error(), "500") || !strings.Contains(err.Error(), "Internal Server Error</body>") {
+		t.Errorf("got error %v, want the status and body of the portal's 500", err)
+	}
+	if err := postResults(results); err != nil {
+		t.Errorf("the portal answered 200: %v", err)
+	}
+}
+
+func TestJolokiaResponseSize(t *testing.T) {
+	defer func(limit int64, proxy string) { *maxJmxBody, *jolokiaURL = limit, proxy }(*maxJmxBody, *jolokiaURL)
+	*maxJmxBody = 128
+
+	threads := `[{"request":{"mbean":"java.lang:type=Threading","attribute":"ThreadCount"},"status":200,"value":1}]`
+	tests := []struct {
+		name   string
+		body   string
+		tooBig bool
+	}{
+		{"under the cap", threads, false},
+		{"exactly the cap", threads + strings.Repeat(" ", 128-len(threads)), false},
+		{"over the cap", threads + strings.Repeat(" ", 129-len(threads)), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			*jolokiaURL = server.URL
+
+			returnChannel := make(chan []TomcatCheckResult, 1)
+			getJmxAttributes(returnChannel, TomcatInstance{ServerID: "tomcat1"}, time.Second)
+			results := <-returnChannel
+
+			if tt.tooBig {
+				if len(results) != 1 || results[0].DataType != "jmx" || results[0].ServerStatus || results[0].FailureReason != "response too large" {
+					t.Errorf("got %+v, want a failed jmx result for a response too large", results)
+				}
+				return
+			}
+			if len(results) != 1 || results[0].DataType != "threads" || results[0].ServerResponse != "1" {
+				t.Errorf("got %+v, want the thread count", results)
+			}
+		})
+	}
+}
+
+func TestHTTPCheckSharedIP(t *testing.T) {
+	defer func(limit int, transport *http.Transport) {
+		*maxConnsPerHost = limit
+		checkTransport = transport
+	}(*maxConnsPerHost, checkTransport)
+	*maxConnsPerHost = 1
+	checkTransport = newCheckTransport()
+
+	// The first instance holds its only connection until the second one has been checked, which
+	// would never happen if the connection limit were shared by the IP
+	secondChecked := make(chan struct{})
+	var once sync.Once
+	first := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-secondChecked:
+		case <-time.After(3 * time.Second):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer first.Close()
+	second := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(secondChecked) })
+	}))
+	defer second.Close()
+
+	var instances []TomcatInstance
+	for i, server := range []*httptest.Server{first, second} {
+		host, port := serverAddress(t, server)
+		instances = append(instances, TomcatInstance{ServerID: "tomcat" + strconv.Itoa(i+1), ServerIP: host, HTTPPort: port})
+	}
+	if instances[0].ServerIP != instances[1].ServerIP {
+		t.Fatalf("test servers don't share an IP: %v and %v", instances[0].ServerIP, instances[1].ServerIP)
+	}
+
+	returnChannel := make(chan []TomcatCheckResult, len(instances))
+	for _, tomcat := range instances {
+		go getHTTPResponseTime(returnChannel, tomcat, "http://"+tomcat.ServerIP+":"+tomcat.HTTPPort+"/", 5*time.Second)
+	}
+	statuses := httpStatuses(waitForDomains(returnChannel, len(instances)))
+	for _, tomcat := range instances {
+		if result, ok := statuses[tomcat.ServerID]; !ok || !result.ServerStatus {
+			t.Errorf("%v on port %v: got %+v, want up", tomcat.ServerID, tomcat.HTTPPort, result)
+		}
+	}
+}
+
+func serverAddress(t *testing.T, server *httptest.Server) (string, string) {
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Hostname(), u.Port()
+}
+
+func TestDecodeJolokiaBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		responses int
+		wantErr   bool
+	}{
+		{"clean", `[{"status":200,"value":1},{"status":404,"error":"not found"}]`, 2, false},
+		{"surrounding whitespace", "\n  [{\"status\":200,\"value\":1}]  \r\n", 1, false},
+		{"empty array", `[]`, 0, false},
+		{"empty body", ``, 0, true},
+		{"leading garbage", `<html>[{"status":200,"value":1}]`, 0, true},
+		{"proxy error page", `<html><body>502 Bad Gateway</body></html>`, 0, true},
+		{"trailing garbage", `[{"status":200,"value":1}]<!-- proxy -->`, 0, true},
+		{"two arrays", `[{"status":200,"value":1}][]`, 0, true},
+		{"object instead of array", `{"status":200,"value":1}`, 0, true},
+		{"truncated", `[{"status":200,"value":1}`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			respJ, err := decodeJolokiaBody([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(respJ) != tt.responses {
+				t.Errorf("got %v responses, want %v", len(respJ), tt.responses)
+			}
+		})
+	}
+}
+
+// jolokiaEcho answers every read of a bulk request with value
+func jolokiaEcho(t *testing.T, value interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requests []map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			t.Error(err)
+			return
+		}
+		responses := make([]map[string]interface{}, len(requests))
+		for i, request := range requests {
+			responses[i] = map[string]interface{}{"request": request, "status": 200, "value": value, "timestamp": time.Now().Unix()}
+		}
+		json.NewEncoder(w).Encode(responses)
+	}))
+}
+
+func TestEachJmxConcurrencyLimit(t *testing.T) {
+	defer func(metrics []JmxMetric) { jmxMetrics = metrics }(jmxMetrics)
+	jmxMetrics = []JmxMetric{{Mbean: "java.lang:type=Threading", Attribute: "ThreadCount", DataType: "threads"}}
+
+	// Every read is held a little so the workers pile up against the limit
+	const limit = 3
+	echo := jolokiaEcho(t, 42)
+	defer echo.Close()
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		echo.Config.Handler.ServeHTTP(w, r)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	var instances []TomcatInstance
+	for i := 0; i < 20; i++ {
+		instances = append(instances, TomcatInstance{ServerID: "tomcat" + strconv.Itoa(i), JolokiaURL: server.URL})
+	}
+
+	done := make(chan []TomcatCheckResult)
+	go func() {
+		done <- eachJmx(instances, limit, getJmxAttributes)
+	}()
+	var results []TomcatCheckResult
+	select {
+	case results = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("eachJmx never returned")
+	}
+
+	threads := make(map[string]bool)
+	for _, result := range results {
+		if result.DataType == "threads" && result.ServerResponse == "42" {
+			threads[result.ServerID] = true
+		}
+	}
+	if len(threads) != len(instances) {
+		t.Errorf("got threads for %v instances, want %v", len(threads), len(instances))
+	}
+	if peak > limit {
+		t.Errorf("%v reads at once, over the limit of %v", peak, limit)
+	}
+}
+
+func TestCheckReusesConnection(t *testing.T) {
+	defer func(limit int64) { *maxBodyBytes = limit }(*maxBodyBytes)
+	*maxBodyBytes = 2 << 20
+
+	// A body the check doesn't look at is still drained, so the next URL reuses the connection
+	var connections int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 1<<20)))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	host, port := serverAddress(t, server)
+	tomcat := TomcatInstance{ServerID: "tomcat1", ServerIP: host, HTTPPort: port, CheckURLs: []string{"/one", "/two"}}
+
+	returnChannel := make(chan []TomcatCheckResult, 1)
+	getHTTPResponseTime(returnChannel, tomcat, server.URL, time.Second)
+	<-returnChannel
+
+	if n := atomic.LoadInt32(&connections); n != 1 {
+		t.Errorf("got %v connections, want 1", n)
+	}
+}
+
+func TestLargeCountersStayExact(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"max int64", "9223372036854775807"},
+		{"past float64 precision", "9007199254740993"},
+		{"min int64", "-9223372036854775808"},
+		{"fraction", "0.125"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			respJ, err := decodeJolokiaBody([]byte(`[{"status":200,"timestamp":1700000000,"value":` + tt.value + `}]`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := formatJolokiaValue(respJ[0].Value)
+			if !ok || got != tt.value {
+				t.Errorf("got %q, want %q", got, tt.value)
+			}
+		})
+	}
+
+	result := TomcatCheckResult{ServerResponse: "9223372036854775807"}
+	if v, ok := resultInt(result); !ok || v != math.MaxInt64 {
+		t.Errorf("resultInt = %v, want %v", v, int64(math.MaxInt64))
+	}
+}
+
+func TestSortResultsStable(t *testing.T) {
+	results := []TomcatCheckResult{
+		{ServerID: "tomcat2", DataType: "time", ServerResponse: "1"},
+		{ServerID: "_monitor", DataType: "heartbeat"},
+		{ServerID: "tomcat1", DataType: "threads"},
+		{ServerID: "tomcat1", DataType: "gc_time", ServerResponse: "first"},
+		{ServerID: "tomcat2", DataType: "memory"},
+		{ServerID: "tomcat1", DataType: "gc_time", ServerResponse: "second"},
+	}
+	want := []TomcatCheckResult{
+		{ServerID: "_monitor", DataType: "heartbeat"},
+		{ServerID: "tomcat1", DataType: "gc_time", ServerResponse: "first"},
+		{ServerID: "tomcat1", DataType: "gc_time", ServerResponse: "second"},
+		{ServerID: "tomcat1", DataType: "threads"},
+		{ServerID: "tomcat2", DataType: "memory"},
+		{ServerID: "tomcat2", DataType: "time", ServerResponse: "1"},
+	}
+
+	// Every order the goroutines could finish in sorts the same
+	for shift := 0; shift < len(results); shift++ {
+		shuffled := append(append([]TomcatCheckResult{}, results[shift:]...), results[:shift]...)
+		sortResults(shuffled)
+		for i := range want {
+			if shuffled[i].ServerID != want[i].ServerID || shuffled[i].DataType != want[i].DataType {
+				t.Fatalf("shift %v: got %v/%v at %v, want %v/%v", shift, shuffled[i].ServerID, shuffled[i].DataType, i, want[i].ServerID, want[i].DataType)
+			}
+		}
+	}
+
+	sortResults(results)
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("got %+v at %v, want %+v", results[i], i, want[i])
+		}
+	}
+}
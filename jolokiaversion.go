@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var jolokiaVersion = flag.String("jolokiaVersion", "1", "response format of the Jolokia agents and proxy: 1, 2, or auto to ask every endpoint for its version once")
+
+// jolokiaVersions are the major versions of the endpoints -jolokiaVersion=auto asked, only
+// answers are kept so an endpoint that was down gets asked again. probes are the questions
+// still out, the reads of an endpoint that come in meanwhile wait for its answer.
+var jolokiaVersions = struct {
+	sync.Mutex
+	endpoints map[string]int
+	probes    map[string]*versionProbe
+}{endpoints: make(map[string]int), probes: make(map[string]*versionProbe)}
+
+// versionProbe is one read of an endpoint's /version, major is set when done is closed
+type versionProbe struct {
+	done  chan struct{}
+	major int
+}
+
+func validateJolokiaVersion() error {
+	switch *jolokiaVersion {
+	case "1", "2", "auto":
+		return nil
+	}
+	return fmt.Errorf("Invalid -jolokiaVersion %q, use 1, 2 or auto", *jolokiaVersion)
+}
+
+// jolokiaMajorVersion is the major version whose response format an endpoint answers with
+func jolokiaMajorVersion(endpoint string, timeout time.Duration) int {
+	switch *jolokiaVersion {
+	case "2":
+		return 2
+	case "auto":
+		return detectJolokiaVersion(endpoint, timeout)
+	}
+	return 1
+}
+
+// detectJolokiaVersion reads the agent version off the endpoint's /version, falling back to
+// 1.x when it can't be read. Reads of an endpoint that's being asked share the one request.
+func detectJolokiaVersion(endpoint string, timeout time.Duration) int {
+	jolokiaVersions.Lock()
+	if major, ok := jolokiaVersions.endpoints[endpoint]; ok {
+		jolokiaVersions.Unlock()
+		return major
+	}
+	if probe, ok := jolokiaVersions.probes[endpoint]; ok {
+		jolokiaVersions.Unlock()
+		<-probe.done
+		return probe.major
+	}
+	probe := &versionProbe{done: make(chan struct{}), major: 1}
+	jolokiaVersions.probes[endpoint] = probe
+	jolokiaVersions.Unlock()
+
+	major, err := readJolokiaVersion(endpoint, timeout)
+	jolokiaVersions.Lock()
+	if err != nil {
+		logger.Debug("Could not read the Jolokia version of ", redactLog(endpoint), ", assuming 1.x: ", redacted(err))
+	} else {
+		logger.Debugf("Jolokia %v answers in the %v.x format", redactLog(endpoint), major)
+		probe.major = major
+		jolokiaVersions.endpoints[endpoint] = major
+	}
+	delete(jolokiaVersions.probes, endpoint)
+	jolokiaVersions.Unlock()
+
+	close(probe.done)
+	return probe.major
+}
+
+func readJolokiaVersion(endpoint string, timeout time.Duration) (int, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return 0, err
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/version"
+
+	respJ, err := getJolokia(u.String(), timeout)
+	if err != nil {
+		return 0, err
+	}
+	version, ok := respJ[0].Value.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("no version in the response, status %v", respJ[0].Status)
+	}
+	agent, _ := version["agent"].(string)
+	major, err := strconv.Atoi(strings.SplitN(agent, ".", 2)[0])
+	if err != nil {
+		return 0, fmt.Errorf("bad agent version %q", agent)
+	}
+	return major, nil
+}
+
+// decodeJolokiaResponse decodes a bulk response in the format of the Jolokia major version
+func decodeJolokiaResponse(contents []byte, major int) (JolokiaRequestResponse, error) {
+	if major >= 2 {
+		return decodeJolokia2Body(contents)
+	}
+	return decodeJolokiaBody(contents)
+}
+
+// jolokia2Response is a bulk response of a Jolokia 2.x agent. Its fields are typed loosely,
+// the numbers as json.Number whether they come quoted or not and the request echo as a plain
+// map, so a changed type fails one field rather than the whole bulk response.
+type jolokia2Response []struct {
+	Timestamp json.Number            `json:"timestamp"`
+	Status    json.Number            `json:"status"`
+	Request   map[string]interface{} `json:"request"`
+	Value     interface{}            `json:"value"`
+	Error     string                 `json:"error"`
+}
+
+// decodeJolokia2Body decodes a 2.x bulk response into the 1.x shape the rest of the code reads
+func decodeJolokia2Body(contents []byte) (JolokiaRequestResponse, error) {
+	var resp2 jolokia2Response
+	if err := decodeJolokiaArray(contents, &resp2); err != nil {
+		return nil, err
+	}
+
+	respJ := make(JolokiaRequestResponse, len(resp2))
+	for i, r := range resp2 {
+		respJ[i].Timestamp, _ = r.Timestamp.Int64()
+		status, _ := r.Status.Int64()
+		respJ[i].Status = int(status)
+		respJ[i].Value = r.Value
+		respJ[i].Error = r.Error
+
+		respJ[i].Request.Mbean, _ = r.Request["mbean"].(string)
+		respJ[i].Request.Attribute = r.Request["attribute"]
+		respJ[i].Request.Operation, _ = r.Request["operation"].(string)
+		respJ[i].Request.Type, _ = r.Request["type"].(string)
+		respJ[i].Request.Path = requestPath(r.Request["path"])
+		if target, ok := r.Request["target"].(map[string]interface{}); ok {
+			respJ[i].Request.Target.URL, _ = target["url"].(string)
+		}
+	}
+	return respJ, nil
+}
+
+// requestPath is the echoed path as the slash separated string it was sent as, whether it
+// came back that way or split into its parts
+func requestPath(path interface{}) string {
+	switch p := path.(type) {
+	case string:
+		return p
+	case []interface{}:
+		parts := make([]string, len(p))
+		for i, part := range p {
+			parts[i] = fmt.Sprint(part)
+		}
+		return strings.Join(parts, "/")
+	}
+	return ""
+}
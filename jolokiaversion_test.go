@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jolokia1Body is a bulk read as a Jolokia 1.x proxy answers it
+const jolokia1Body = `[
+  {"request":{"mbean":"java.lang:type=Memory","attribute":"HeapMemoryUsage","path":"used","type":"read","target":{"url":"service:jmx:rmi:///jndi/rmi://10.0.0.1:9000/jmxrmi"}},
+   "value":536870912,"timestamp":1700000000,"status":200},
+  {"request":{"mbean":"java.lang:type=Threading","attribute":"ThreadCount","type":"read","target":{"url":"service:jmx:rmi:///jndi/rmi://10.0.0.1:9000/jmxrmi"}},
+   "value":212,"timestamp":1700000000,"status":200},
+  {"request":{"mbean":"java.lang:name=G1 Young Generation,type=GarbageCollector","attribute":"LastGcInfo","type":"read","target":{"url":"service:jmx:rmi:///jndi/rmi://10.0.0.1:9000/jmxrmi"}},
+   "value":{"duration":12,"memoryUsageAfterGc":{"G1 Eden Space":{"used":0,"max":-1}}},"timestamp":1700000000,"status":200},
+  {"request":{"mbean":"org.sakaiproject:name=Sessions","attribute":"Active15Min","type":"read","target":{"url":"service:jmx:rmi:///jndi/rmi://10.0.0.1:9000/jmxrmi"}},
+   "error_type":"javax.management.InstanceNotFoundException","error":"org.sakaiproject:name=Sessions","status":404,"timestamp":1700000000}
+]`
+
+// jolokia2Body is the same read from a 2.x agent, with the variations decodeJolokia2Body takes:
+// quoted numbers, a split path echo and deeper nesting
+const jolokia2Body = `[
+  {"request":{"mbean":"java.lang:type=Memory","attribute":"HeapMemoryUsage","path":["used"],"type":"read"},
+   "value":536870912,"timestamp":"1700000000","status":200},
+  {"request":{"mbean":"java.lang:type=Threading","attribute":"ThreadCount","type":"read"},
+   "value":212,"timestamp":"1700000000","status":200},
+  {"request":{"mbean":"java.lang:name=G1 Young Generation,type=GarbageCollector","attribute":"LastGcInfo","type":"read"},
+   "value":{"duration":12,"memoryUsageAfterGc":{"G1 Eden Space":{"used":0,"max":-1,"committed":{"value":0,"unit":"bytes"}}}},"timestamp":"1700000000","status":200},
+  {"request":{"mbean":"org.sakaiproject:name=Sessions","attribute":"Active15Min","type":"read"},
+   "error_type":"javax.management.InstanceNotFoundException","error":"org.sakaiproject:name=Sessions","status":"404","timestamp":"1700000000"}
+]`
+
+func TestDecodeJolokiaVersions(t *testing.T) {
+	if _, err := decodeJolokiaResponse([]byte(jolokia2Body), 1); err == nil {
+		t.Error("the 1.x struct decoded a 2.x body, the formats are meant to differ")
+	}
+
+	for _, tt := range []struct {
+		name  string
+		body  string
+		major int
+	}{
+		{"1.x", jolokia1Body, 1},
+		{"2.x", jolokia2Body, 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			respJ, err := decodeJolokiaResponse([]byte(tt.body), tt.major)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(respJ) != 4 {
+				t.Fatalf("got %v responses, want 4", len(respJ))
+			}
+
+			heap := respJ[0]
+			if heap.Status != 200 || heap.Timestamp != 1700000000 || heap.Request.Mbean != "java.lang:type=Memory" || heap.Request.Path != "used" {
+				t.Errorf("heap read decoded as %+v", heap)
+			}
+			if v, ok := formatJolokiaValue(heap.Value); !ok || v != "536870912" {
+				t.Errorf("heap used is %q", v)
+			}
+
+			gc, ok := respJ[2].Value.(map[string]interface{})
+			if !ok || gc["duration"] == nil {
+				t.Errorf("LastGcInfo decoded as %#v", respJ[2].Value)
+			}
+
+			missing := respJ[3]
+			if missing.Status != http.StatusNotFound || missing.Error != "org.sakaiproject:name=Sessions" || missing.Value != nil {
+				t.Errorf("failed read decoded as %+v", missing)
+			}
+
+			tomcat := TomcatInstance{ServerID: "app1"}
+			matched := false
+			for _, metric := range jmxMetrics {
+				if metric.matches(respJ[1].Request.Mbean, respJ[1].Request.Attribute, respJ[1].Request.Path, respJ[1].Request.Operation) {
+					matched = true
+					results := metric.results(tomcat, respJ[1].Value)
+					if len(results) != 1 || results[0].DataType != "threads" || results[0].ServerResponse != "212" {
+						t.Errorf("ThreadCount gave %+v", results)
+					}
+				}
+			}
+			if !matched {
+				t.Error("no metric matched the ThreadCount read")
+			}
+		})
+	}
+}
+
+func TestDetectJolokiaVersion(t *testing.T) {
+	defer func(version string) { *jolokiaVersion = version }(*jolokiaVersion)
+	*jolokiaVersion = "auto"
+
+	versionReads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Path == "/jolokia/version" {
+			versionReads++
+			w.Write([]byte(`{"request":{"type":"version"},"value":{"agent":"2.0.2","protocol":"8.0"},"timestamp":1700000000,"status":200}`))
+			return
+		}
+		w.Write([]byte(jolokia2Body))
+	}))
+	defer server.Close()
+
+	endpoint := server.URL + "/jolokia"
+	for i := 0; i < 2; i++ {
+		respJ, err := postJolokia(endpoint, []JolokiaRequest{heapUsedRequest("")}, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if respJ[0].Timestamp != 1700000000 {
+			t.Errorf("got %+v", respJ[0])
+		}
+	}
+	if versionReads != 1 {
+		t.Errorf("read the version %v times, want once", versionReads)
+	}
+}
+
+func TestDetectJolokiaVersionConcurrent(t *testing.T) {
+	var versionReads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&versionReads, 1)
+		// Slow enough that every reader arrives while the first question is still out
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(`{"request":{"type":"version"},"value":{"agent":"2.0.2","protocol":"8.0"},"timestamp":1700000000,"status":200}`))
+	}))
+	defer server.Close()
+
+	endpoint := server.URL + "/jolokia"
+	majors := make(chan int, 10)
+	for i := 0; i < cap(majors); i++ {
+		go func() { majors <- detectJolokiaVersion(endpoint, time.Second) }()
+	}
+	for i := 0; i < cap(majors); i++ {
+		if major := <-majors; major != 2 {
+			t.Errorf("got version %v, want 2", major)
+		}
+	}
+	if n := atomic.LoadInt32(&versionReads); n != 1 {
+		t.Errorf("read the version %v times, want once", n)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+var kafkaBrokers = flag.String("kafkaBroker", "", "comma separated Kafka brokers to also publish the results to")
+var kafkaTopic = flag.String("kafkaTopic", "jmx-cron", "Kafka topic the results are published to")
+var kafkaFlushTimeout = flag.Duration("kafkaFlushTimeout", 10*time.Second, "how long to wait at exit for results still queued for Kafka")
+
+// kafkaWriter batches messages in the background so a slow or unreachable broker never holds
+// up the checks or the portal POST. It's shared by every run in -interval mode.
+var kafkaWriter *kafka.Writer
+
+func newKafkaWriter() *kafka.Writer {
+	return &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(*kafkaBrokers, ",")...),
+		Topic:        *kafkaTopic,
+		Balancer:     &kafka.Hash{},
+		Async:        true,
+		BatchTimeout: 100 * time.Millisecond,
+		WriteTimeout: 10 * time.Second,
+		Completion: func(messages []kafka.Message, err error) {
+			if err != nil {
+				logger.Errorf("Could not publish %v results to Kafka: %v", len(messages), err)
+			}
+		},
+	}
+}
+
+// publishToKafka queues one message per result, keyed by ServerID so all the results of an
+// instance land on the same partition in order
+func publishToKafka(results []TomcatCheckResult) {
+	if kafkaWriter == nil {
+		kafkaWriter = newKafkaWriter()
+	}
+
+	now := time.Now()
+	messages := make([]kafka.Message, 0, len(results))
+	for _, result := range results {
+		value, err := json.Marshal(result)
+		if err != nil {
+			logger.Error("Could not marshal result for Kafka", err)
+			continue
+		}
+		messages = append(messages, kafka.Message{Key: []byte(result.ServerID), Value: value, Time: now})
+	}
+
+	// Async, this only queues the messages
+	if err := kafkaWriter.WriteMessages(context.Background(), messages...); err != nil {
+		logger.Error("Could not queue results for Kafka", err)
+	}
+}
+
+// flushKafka sends whatever is still queued, giving up after -kafkaFlushTimeout
+func flushKafka() {
+	if kafkaWriter == nil {
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- kafkaWriter.Close()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			logger.Error("Could not flush Kafka results", err)
+		}
+	case <-time.After(*kafkaFlushTimeout):
+		logger.Warningf("Kafka results not flushed after %v, giving up", *kafkaFlushTimeout)
+	}
+}
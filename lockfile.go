@@ -0,0 +1,5 @@
+package main
+
+import "flag"
+
+var lockFile = flag.String("lockFile", "", "hold an exclusive lock on this file while running, a run that can't get it exits instead of overlapping the previous one")
@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+// acquireLock isn't supported on Windows, runs go ahead unlocked
+func acquireLock(path string) (release func(), ok bool, err error) {
+	logger.Warning("-lockFile is not supported on Windows, running without a lock")
+	return func() {}, true, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"regexp"
+	"strings"
+)
+
+var mbeanInclude = flag.String("mbeanInclude", "", "comma separated globs, only beans matching one are kept from pattern reads")
+var mbeanExclude = flag.String("mbeanExclude", "", "comma separated globs of beans to drop from pattern reads")
+
+var includeGlobs, excludeGlobs []*regexp.Regexp
+
+// compileGlobs turns a comma separated list of globs into regexps. Only * and ? are special,
+// unlike path.Match a * also matches the / found in names like context=/portal.
+func compileGlobs(globs string) []*regexp.Regexp {
+	var compiled []*regexp.Regexp
+	for _, glob := range strings.Split(globs, ",") {
+		glob = strings.TrimSpace(glob)
+		if glob == "" {
+			continue
+		}
+
+		pattern := regexp.QuoteMeta(glob)
+		pattern = strings.Replace(pattern, `\*`, ".*", -1)
+		pattern = strings.Replace(pattern, `\?`, ".", -1)
+		compiled = append(compiled, regexp.MustCompile("^"+pattern+"$"))
+	}
+	return compiled
+}
+
+func matchesAny(name string, globs []*regexp.Regexp) bool {
+	for _, glob := range globs {
+		if glob.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepMbean applies -mbeanInclude and -mbeanExclude to a bean returned by a pattern read
+func keepMbean(objectName string) bool {
+	if len(includeGlobs) > 0 && !matchesAny(objectName, includeGlobs) {
+		return false
+	}
+	return !matchesAny(objectName, excludeGlobs)
+}
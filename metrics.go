@@ -0,0 +1,496 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var metricsFile = flag.String("metrics", "", "JSON file describing the Jolokia reads to make, replaces the built-in metrics")
+
+// JmxMetric is one Jolokia request and how its response turns into check results.
+//
+// The shape of the value Jolokia returns depends on the request:
+//   - a single attribute (with or without a path) returns a scalar, or an object for composite
+//     attributes like HeapMemoryUsage, in which case Field picks the key to report. Without a
+//     Field every key is reported, with the key appended to the DataType (memory_used,
+//     memory_max, ...)
+//   - several Attributes return an object keyed by attribute name, DataTypes maps each one to
+//     its DataType
+//   - a Pattern read (wildcard mbean) returns an object keyed by the matching bean names, and each
+//     bean gets its own result with the bean's name appended to the DataType
+type JmxMetric struct {
+	Type       string            `json:"type"` // read (default) or exec
+	Mbean      string            `json:"mbean"`
+	Attribute  string            `json:"attribute,omitempty"`
+	Attributes []string          `json:"attributes,omitempty"`
+	Path       string            `json:"path,omitempty"`
+	Operation  string            `json:"operation,omitempty"`
+	Arguments  []interface{}     `json:"arguments,omitempty"`
+	Pattern    bool              `json:"pattern,omitempty"`
+	Field      string            `json:"field,omitempty"`
+	Percent    bool              `json:"percent,omitempty"` // 0.0-1.0 values are reported as a percentage
+	Parse      string            `json:"parse,omitempty"`   // jvmFlags: value is a list of JVM arguments
+	DataType   string            `json:"dataType,omitempty"`
+	DataTypes  map[string]string `json:"dataTypes,omitempty"`
+	Static     bool              `json:"static,omitempty"`   // rarely changes, cached for -staticMetricTTL
+	Projects   string            `json:"projects,omitempty"` // only read on instances whose ProjectName contains this
+}
+
+// defaultMetrics are read from every instance unless -metrics replaces them
+var defaultMetrics = []JmxMetric{
+	{Mbean: "java.lang:type=Memory", Attributes: []string{"HeapMemoryUsage", "NonHeapMemoryUsage"}, Field: "used",
+		DataTypes: map[string]string{"HeapMemoryUsage": "memory", "NonHeapMemoryUsage": "nonheap_memory"}},
+	{Mbean: "java.lang:type=Threading", Attribute: "ThreadCount", DataType: "threads"},
+	{Mbean: "java.lang:type=OperatingSystem", Attribute: "ProcessCpuTime", DataType: "cpu"},
+	{Mbean: "org.sakaiproject:name=Sessions", Attribute: "Active15Min", DataType: "sessions"},
+	// Sakai's own beans, only read where the ProjectName says sakai, as for the login page check
+	{Mbean: "org.sakaiproject:name=MemoryService", Attributes: []string{"CacheHits", "CacheMisses"}, Projects: "sakai",
+		DataTypes: map[string]string{"CacheHits": "cache_hits", "CacheMisses": "cache_misses"}},
+	{Mbean: "org.sakaiproject:name=EventTrackingService", Attribute: "QueueSize", DataType: "event_queue", Projects: "sakai"},
+	{Mbean: "com.zaxxer.hikari:type=Pool (sakai)", Attribute: "ActiveConnections", DataType: "db"},
+	{Mbean: "java.lang:name=ConcurrentMarkSweep,type=GarbageCollector", Attribute: "CollectionTime", DataType: "gc"},
+	// Every collector, summed into gc_overhead_percent when there's a state file
+	{Mbean: "java.lang:type=GarbageCollector,name=*", Attribute: "CollectionTime", Pattern: true, DataType: "gc_time"},
+	// LastGcInfo is a composite, or null until the collector has run once, which reports nothing
+	{Mbean: "java.lang:type=GarbageCollector,name=*", Attribute: "LastGcInfo", Field: "duration", Pattern: true, DataType: "last_gc_ms"},
+	// ProcessCpuLoad and SystemCpuLoad are HotSpot-only, other JVMs return an error for them
+	{Mbean: "java.lang:type=OperatingSystem", Attribute: "ProcessCpuLoad", Percent: true, DataType: "process_cpu_load"},
+	{Mbean: "java.lang:type=OperatingSystem", Attribute: "SystemCpuLoad", Percent: true, DataType: "system_cpu_load"},
+	// Counters per webapp, a rising rejected count means maxActiveSessions is being hit
+	{Mbean: "Catalina:type=Manager,context=*,host=*", Attributes: []string{"expiredSessions", "rejectedSessions", "maxActiveSessions"}, Pattern: true,
+		DataTypes: map[string]string{"expiredSessions": "sessions_expired", "rejectedSessions": "sessions_rejected", "maxActiveSessions": "sessions_max"}},
+	// One bean per connector, how close each is to running out of connections
+	{Mbean: "Catalina:type=ProtocolHandler,port=*", Attributes: []string{"connectionCount", "maxConnections"}, Pattern: true,
+		DataTypes: map[string]string{"connectionCount": "conn_current", "maxConnections": "conn_max"}},
+	{Mbean: "java.lang:type=Runtime", Attribute: "Uptime", DataType: "uptime"},
+	{Mbean: "java.lang:type=Runtime", Attribute: "InputArguments", Parse: "jvmFlags", Static: true},
+	{Mbean: "java.lang:type=Runtime", Attributes: []string{"VmName", "VmVersion", "SpecVersion"}, Static: true,
+		DataTypes: map[string]string{"VmName": "vm_name", "VmVersion": "vm_version", "SpecVersion": "java_version"}},
+	// Errors out, and is skipped, on JVMs where CompilationTimeMonitoringSupported is false
+	{Mbean: "java.lang:type=Compilation", Attribute: "TotalCompilationTime", DataType: "compile_time"},
+}
+
+// jmxMetrics is what getJmxAttributes reads, the defaults or the -metrics file
+var jmxMetrics = defaultMetrics
+
+// loadMetrics reads and validates the -metrics file
+func loadMetrics(path string) ([]JmxMetric, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []JmxMetric
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return nil, err
+	}
+	if len(metrics) == 0 {
+		return nil, errors.New("no metrics defined")
+	}
+
+	for i, metric := range metrics {
+		if err := metric.validate(); err != nil {
+			return nil, fmt.Errorf("metric %v (%v): %v", i, metric.Mbean, err)
+		}
+	}
+	return metrics, nil
+}
+
+func (m JmxMetric) validate() error {
+	if m.Mbean == "" {
+		return errors.New("mbean is required")
+	}
+
+	switch m.requestType() {
+	case "READ":
+		if m.Attribute == "" && len(m.Attributes) == 0 {
+			return errors.New("read needs an attribute or attributes")
+		}
+	case "EXEC":
+		if m.Operation == "" {
+			return errors.New("exec needs an operation")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", m.Type)
+	}
+
+	switch m.Parse {
+	case "":
+	case "jvmFlags":
+		// Reports its own DataTypes
+		return nil
+	default:
+		return fmt.Errorf("unknown parse %q", m.Parse)
+	}
+
+	for _, attribute := range m.attributeNames() {
+		if m.dataTypeFor(attribute) == "" {
+			return fmt.Errorf("no dataType for %v", attribute)
+		}
+	}
+	if len(m.attributeNames()) == 0 && m.DataType == "" {
+		return errors.New("dataType is required")
+	}
+	return nil
+}
+
+func (m JmxMetric) requestType() string {
+	if m.Type == "" {
+		return "READ"
+	}
+	return strings.ToUpper(m.Type)
+}
+
+// attributeNames lists the attributes this metric reads
+func (m JmxMetric) attributeNames() []string {
+	if len(m.Attributes) > 0 {
+		return m.Attributes
+	}
+	if m.Attribute != "" {
+		return []string{m.Attribute}
+	}
+	return nil
+}
+
+// dataTypeFor is the DataType an attribute is reported as: its entry in DataTypes, the metric's
+// DataType, or else the attribute name in snake case (HeapMemoryUsage is heap_memory_usage)
+func (m JmxMetric) dataTypeFor(attribute string) string {
+	if dataType, ok := m.DataTypes[attribute]; ok {
+		return dataType
+	}
+	if m.DataType != "" {
+		return m.DataType
+	}
+	return snakeCase(attribute)
+}
+
+var camelBoundary = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+
+func snakeCase(name string) string {
+	return strings.ToLower(invalidLabelChars.ReplaceAllString(camelBoundary.ReplaceAllString(name, "${1}_${2}"), "_"))
+}
+
+// appliesTo tells whether the metric is read on an instance
+func (m JmxMetric) appliesTo(tomcat TomcatInstance) bool {
+	return m.Projects == "" || strings.Contains(tomcat.ProjectName, m.Projects)
+}
+
+// request builds the Jolokia request for this metric, proxied to jmxURL unless it's empty
+func (m JmxMetric) request(jmxURL string) JolokiaRequest {
+	req := JolokiaRequest{
+		Type:      m.requestType(),
+		Mbean:     m.Mbean,
+		Path:      m.Path,
+		Operation: m.Operation,
+		Arguments: m.Arguments,
+	}
+	if len(m.Attributes) > 0 {
+		req.Attribute = m.Attributes
+	} else if m.Attribute != "" {
+		req.Attribute = m.Attribute
+	}
+	if jmxURL != "" {
+		req.Target = &JolokiaTarget{URL: jmxURL}
+	}
+	return req
+}
+
+// matches tells whether a Jolokia response belongs to this metric
+func (m JmxMetric) matches(mbean string, attribute interface{}, path string, operation string) bool {
+	if canonicalObjectName(mbean) != canonicalObjectName(m.Mbean) || path != m.Path {
+		return false
+	}
+	if m.requestType() == "EXEC" {
+		return operation == m.Operation
+	}
+
+	switch a := attribute.(type) {
+	case string:
+		return len(m.Attributes) == 0 && a == m.Attribute
+	case []interface{}:
+		if len(a) != len(m.Attributes) {
+			return false
+		}
+		for i := range a {
+			if a[i] != m.Attributes[i] {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// results turns the value of a successful Jolokia response into check results
+func (m JmxMetric) results(tomcat TomcatInstance, value interface{}) []TomcatCheckResult {
+	if m.Parse == "jvmFlags" {
+		return jvmFlagResults(tomcat, value)
+	}
+	if !m.Pattern {
+		return m.beanResults(tomcat, value, "")
+	}
+
+	beans, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	names := make([]string, 0, len(beans))
+	for name := range beans {
+		if keepMbean(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var results []TomcatCheckResult
+	for _, name := range names {
+		results = append(results, m.beanResults(tomcat, beans[name], "_"+mbeanLabel(name))...)
+	}
+	return results
+}
+
+// beanResults handles the value of one bean, suffix is appended to the DataType
+func (m JmxMetric) beanResults(tomcat TomcatInstance, value interface{}, suffix string) []TomcatCheckResult {
+	var results []TomcatCheckResult
+
+	attributes := m.attributeNames()
+	if m.Pattern || len(m.Attributes) > 0 {
+		// The value is keyed by attribute name
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for _, attribute := range attributes {
+			results = append(results, m.valueResults(tomcat, m.dataTypeFor(attribute), suffix, values[attribute])...)
+		}
+		return results
+	}
+
+	dataType := m.DataType
+	if len(attributes) == 1 {
+		dataType = m.dataTypeFor(attributes[0])
+	}
+	return m.valueResults(tomcat, dataType, suffix, value)
+}
+
+// valueResults reports one attribute value. A composite value without a Field to pick from
+// it gets a result per key, so one read covers all of HeapMemoryUsage.
+func (m JmxMetric) valueResults(tomcat TomcatInstance, dataType string, suffix string, value interface{}) []TomcatCheckResult {
+	composite, ok := value.(map[string]interface{})
+	if !ok || m.Field != "" {
+		if v, ok := m.format(value); ok {
+			return []TomcatCheckResult{newCheckResult(tomcat, true, dataType+suffix, v)}
+		}
+		return nil
+	}
+
+	keys := make([]string, 0, len(composite))
+	for key := range composite {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var results []TomcatCheckResult
+	for _, key := range keys {
+		if v, ok := m.format(composite[key]); ok {
+			results = append(results, newCheckResult(tomcat, true, dataType+"_"+snakeCase(key)+suffix, v))
+		}
+	}
+	return results
+}
+
+// format applies Field and Percent to a single attribute value, numbers are reported as
+// numbers and anything else scalar as text
+func (m JmxMetric) format(value interface{}) (string, bool) {
+	if m.Field != "" {
+		composite, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		value = composite[m.Field]
+	}
+	if m.Percent {
+		return loadPercent(value)
+	}
+	if v, ok := formatJolokiaValue(value); ok {
+		return v, true
+	}
+	return formatJolokiaText(value)
+}
+
+// formatJolokiaText renders the text and flag values of attributes like a VM version, a
+// verbose switch or an application's RUNNING/DEGRADED status
+func formatJolokiaText(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, v != ""
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// labelProperties are the ObjectName properties that identify a bean best, in order
+var labelProperties = []string{"name=", "context=", "port="}
+
+// mbeanLabel shortens an ObjectName from a pattern read to something usable in a DataType,
+// preferring its name property, then its webapp context or connector port
+func mbeanLabel(objectName string) string {
+	properties := objectName
+	if i := strings.Index(objectName, ":"); i >= 0 {
+		properties = objectName[i+1:]
+	}
+
+	found := false
+	for _, prefix := range labelProperties {
+		if value, ok := mbeanProperty(properties, prefix); ok {
+			properties, found = value, true
+			break
+		}
+	}
+
+	label := strings.Trim(invalidLabelChars.ReplaceAllString(strings.Trim(properties, `"`), "_"), "_")
+	if found && label == "" {
+		// The root webapp's context is just /
+		return "ROOT"
+	}
+	return label
+}
+
+// mbeanProperty returns the value of the property starting with prefix
+func mbeanProperty(properties string, prefix string) (string, bool) {
+	for _, property := range strings.Split(properties, ",") {
+		if strings.HasPrefix(property, prefix) {
+			return strings.TrimPrefix(property, prefix), true
+		}
+	}
+	return "", false
+}
+
+// canonicalObjectName puts an ObjectName's key properties in sorted order, as JMX's own
+// canonical form does, and drops the spaces around its separators, so a name Jolokia echoes
+// back reordered still matches the configured one. Names are case sensitive, values are left
+// alone.
+func canonicalObjectName(name string) string {
+	i := strings.Index(name, ":")
+	if i < 0 {
+		return strings.TrimSpace(name)
+	}
+
+	properties := splitProperties(name[i+1:])
+	for j, property := range properties {
+		if k := strings.Index(property, "="); k >= 0 {
+			property = strings.TrimSpace(property[:k]) + "=" + strings.TrimSpace(property[k+1:])
+		}
+		properties[j] = strings.TrimSpace(property)
+	}
+	sort.Strings(properties)
+	return strings.TrimSpace(name[:i]) + ":" + strings.Join(properties, ",")
+}
+
+// splitProperties splits key properties on the commas that aren't inside a quoted value
+func splitProperties(properties string) []string {
+	var split []string
+	quoted, escaped := false, false
+	start := 0
+	for i, c := range properties {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			split = append(split, properties[start:i])
+			start = i + 1
+		}
+	}
+	return append(split, properties[start:])
+}
+
+// jvmSizeFlags maps the JVM flags we audit to the DataType their size is reported as
+var jvmSizeFlags = []struct {
+	prefix   string
+	dataType string
+}{
+	{"-Xmx", "xmx"},
+	{"-Xms", "xms"},
+	{"-XX:MaxMetaspaceSize=", "maxmeta"},
+}
+
+// jvmFlagResults pulls the heap and metaspace sizes, in bytes, out of the JVM's InputArguments.
+// The arguments themselves can hold passwords, they're only kept for the -baseline check.
+func jvmFlagResults(tomcat TomcatInstance, value interface{}) []TomcatCheckResult {
+	arguments, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var results []TomcatCheckResult
+	if len(baselines) > 0 {
+		var args []string
+		for _, argument := range arguments {
+			if s, ok := argument.(string); ok {
+				args = append(args, s)
+			}
+		}
+		recordJvmArgs(tomcat, args)
+	}
+
+	for _, sizeFlag := range jvmSizeFlags {
+		// The JVM honors the last occurrence of a flag
+		size := int64(-1)
+		for _, argument := range arguments {
+			s, ok := argument.(string)
+			if !ok || !strings.HasPrefix(s, sizeFlag.prefix) {
+				continue
+			}
+			if n, err := parseJvmSize(strings.TrimPrefix(s, sizeFlag.prefix)); err == nil {
+				size = n
+			}
+		}
+		if size >= 0 {
+			results = append(results, newCheckResult(tomcat, true, sizeFlag.dataType, strconv.FormatInt(size, 10)))
+		}
+	}
+	return results
+}
+
+// parseJvmSize parses a JVM memory size like 512m or 4G into bytes
+func parseJvmSize(size string) (int64, error) {
+	if size == "" {
+		return 0, errors.New("empty size")
+	}
+
+	multiplier := int64(1)
+	switch size[len(size)-1] {
+	case 'k', 'K':
+		multiplier = 1 << 10
+	case 'm', 'M':
+		multiplier = 1 << 20
+	case 'g', 'G':
+		multiplier = 1 << 30
+	case 't', 'T':
+		multiplier = 1 << 40
+	}
+	if multiplier > 1 {
+		size = size[:len(size)-1]
+	}
+
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+// heapMemoryUsageBody is what a Jolokia 1.x agent answers to a HeapMemoryUsage read without a path
+const heapMemoryUsageBody = `[{"request":{"mbean":"java.lang:type=Memory","attribute":"HeapMemoryUsage","type":"read"},` +
+	`"value":{"init":268435456,"committed":1073741824,"max":4294967296,"used":712345678},"timestamp":1700000000,"status":200}]`
+
+func TestCompositeValueResults(t *testing.T) {
+	respJ, err := decodeJolokiaBody([]byte(heapMemoryUsageBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tomcat := TomcatInstance{ServerID: "tomcat1"}
+
+	tests := []struct {
+		name   string
+		metric JmxMetric
+		want   map[string]string
+	}{
+		{
+			"every key without a field",
+			JmxMetric{Mbean: "java.lang:type=Memory", Attribute: "HeapMemoryUsage", DataType: "memory"},
+			map[string]string{"memory_init": "268435456", "memory_committed": "1073741824", "memory_max": "4294967296", "memory_used": "712345678"},
+		},
+		{
+			"one key with a field",
+			JmxMetric{Mbean: "java.lang:type=Memory", Attribute: "HeapMemoryUsage", Field: "used", DataType: "memory"},
+			map[string]string{"memory": "712345678"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.metric.matches(respJ[0].Request.Mbean, respJ[0].Request.Attribute, respJ[0].Request.Path, respJ[0].Request.Operation) {
+				t.Fatal("metric doesn't match the response")
+			}
+
+			results := tt.metric.results(tomcat, respJ[0].Value)
+			if len(results) != len(tt.want) {
+				t.Fatalf("got %v results, want %v: %+v", len(results), len(tt.want), results)
+			}
+			for _, result := range results {
+				if want, ok := tt.want[result.DataType]; !ok || result.ServerResponse != want || !result.ServerStatus {
+					t.Errorf("got %v = %v, want %v", result.DataType, result.ServerResponse, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCanonicalObjectName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"java.lang:type=Memory", "java.lang:type=Memory"},
+		{"java.lang:type=GarbageCollector,name=G1 Young Generation", "java.lang:name=G1 Young Generation,type=GarbageCollector"},
+		{" java.lang : type = GarbageCollector , name=G1 Young Generation ", "java.lang:name=G1 Young Generation,type=GarbageCollector"},
+		{`Catalina:type=Manager,host="a,b",context=/portal`, `Catalina:context=/portal,host="a,b",type=Manager`},
+		{`Catalina:type=Manager,host="a\",b",context=/`, `Catalina:context=/,host="a\",b",type=Manager`},
+		{"java.lang:type=GarbageCollector,name=*", "java.lang:name=*,type=GarbageCollector"},
+		{"Catalina:type=Manager,*", "Catalina:*,type=Manager"},
+		{"no-properties", "no-properties"},
+	}
+
+	for _, tt := range tests {
+		if got := canonicalObjectName(tt.name); got != tt.want {
+			t.Errorf("canonicalObjectName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchesReorderedObjectName(t *testing.T) {
+	metric := JmxMetric{Mbean: "java.lang:type=GarbageCollector,name=ConcurrentMarkSweep", Attribute: "CollectionTime"}
+
+	tests := []struct {
+		mbean string
+		want  bool
+	}{
+		{"java.lang:type=GarbageCollector,name=ConcurrentMarkSweep", true},
+		{"java.lang:name=ConcurrentMarkSweep,type=GarbageCollector", true},
+		{"java.lang:name=ConcurrentMarkSweep, type=GarbageCollector", true},
+		{"java.lang:name=concurrentmarksweep,type=GarbageCollector", false},
+		{"java.lang:name=ParNew,type=GarbageCollector", false},
+	}
+
+	for _, tt := range tests {
+		if got := metric.matches(tt.mbean, "CollectionTime", "", ""); got != tt.want {
+			t.Errorf("matches(%q) = %v, want %v", tt.mbean, got, tt.want)
+		}
+	}
+}
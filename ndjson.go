@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"sync"
+)
+
+var ndjsonPath = flag.String("ndjson", "", "also write every result as a line of JSON to this file, - for stdout, as the checks finish")
+
+// ndjson writes straight to the file, unbuffered, so every line is out as soon as it's written
+// and someone tailing the file sees results while the run goes on
+var ndjson = struct {
+	sync.Mutex
+	w        io.Writer
+	streamed map[[2]string]bool
+}{streamed: make(map[[2]string]bool)}
+
+// openNDJSON opens the -ndjson file for appending, so runs in -interval mode follow each other
+func openNDJSON() error {
+	if *ndjsonPath == "-" {
+		ndjson.w = os.Stdout
+		return nil
+	}
+	f, err := os.OpenFile(*ndjsonPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	ndjson.w = f
+	return nil
+}
+
+// streamNDJSON writes the results of a check that just finished
+func streamNDJSON(results []TomcatCheckResult) {
+	if ndjson.w == nil {
+		return
+	}
+
+	ndjson.Lock()
+	defer ndjson.Unlock()
+	for _, result := range results {
+		line, err := json.Marshal(result)
+		if err != nil {
+			continue
+		}
+		if _, err := ndjson.w.Write(append(line, '\n')); err != nil {
+			logger.Error("Could not write ndjson", err)
+			return
+		}
+		ndjson.streamed[[2]string{result.ServerID, result.DataType}] = true
+	}
+}
+
+// finishNDJSON writes the results worked out at the end of the run, like the heartbeat, that
+// weren't streamed as the checks finished. What's already out stays as the check reported it.
+func finishNDJSON(results []TomcatCheckResult) {
+	if ndjson.w == nil {
+		return
+	}
+
+	var rest []TomcatCheckResult
+	ndjson.Lock()
+	for _, result := range results {
+		if !ndjson.streamed[[2]string{result.ServerID, result.DataType}] {
+			rest = append(rest, result)
+		}
+	}
+	ndjson.Unlock()
+
+	streamNDJSON(rest)
+
+	ndjson.Lock()
+	ndjson.streamed = make(map[[2]string]bool)
+	ndjson.Unlock()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var oauthTokenURL = flag.String("oauthTokenURL", "", "OAuth2 token endpoint, to authenticate to the admin portal with client credentials instead of -token")
+var oauthClientID = flag.String("oauthClientID", "", "OAuth2 client id, with -oauthTokenURL")
+var oauthClientSecret = flag.String("oauthClientSecret", "", "OAuth2 client secret, with -oauthTokenURL")
+
+// oauthToken is the current bearer token, refreshed a little before it expires. Its lock is
+// only held to read or swap the token, never across the fetch: the fetch logs through
+// redactLog, which reads the token too.
+var oauthToken = struct {
+	sync.Mutex
+	value   string
+	expires time.Time
+}{}
+
+// oauthRefresh lets one caller at a time fetch a token, the others wait for it
+var oauthRefresh sync.Mutex
+
+// oauthRefreshMargin refreshes a token that would expire while a request is in flight
+const oauthRefreshMargin = 30 * time.Second
+
+func oauthConfigured() bool {
+	return *oauthTokenURL != ""
+}
+
+func validateOAuth() error {
+	if *oauthTokenURL == "" && *oauthClientID == "" && *oauthClientSecret == "" {
+		return nil
+	}
+	if *oauthTokenURL == "" || *oauthClientID == "" || *oauthClientSecret == "" {
+		return errors.New("-oauthTokenURL, -oauthClientID and -oauthClientSecret are all required for OAuth2")
+	}
+	if u, err := url.Parse(*oauthTokenURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid OAuth2 token URL %q", *oauthTokenURL)
+	}
+	return nil
+}
+
+// setPortalAuth authenticates a request to the admin portal, with the OAuth2 bearer token when
+// it's configured and the static -token otherwise
+func setPortalAuth(req *http.Request) error {
+	if !oauthConfigured() {
+		req.Header.Set("X-Auth-Token", *token)
+		return nil
+	}
+
+	bearer, err := currentOAuthToken()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+bearer)
+	return nil
+}
+
+// currentOAuthToken returns the bearer token, fetching a new one when there is none yet or it's
+// about to expire, which happens between runs in -interval mode
+func currentOAuthToken() (string, error) {
+	if value, ok := validOAuthToken(); ok {
+		return value, nil
+	}
+
+	oauthRefresh.Lock()
+	defer oauthRefresh.Unlock()
+	// Someone else may have refreshed it while this caller waited
+	if value, ok := validOAuthToken(); ok {
+		return value, nil
+	}
+
+	value, expiresIn, err := fetchOAuthToken()
+	if err != nil {
+		return "", fmt.Errorf("Could not get an OAuth2 token: %v", err)
+	}
+
+	oauthToken.Lock()
+	oauthToken.value = value
+	oauthToken.expires = time.Time{}
+	if expiresIn > 0 {
+		oauthToken.expires = time.Now().Add(expiresIn)
+	}
+	oauthToken.Unlock()
+
+	logger.Debug("Fetched an OAuth2 token, expires in ", expiresIn)
+	return value, nil
+}
+
+// validOAuthToken returns the current token unless there is none or it's about to expire
+func validOAuthToken() (string, bool) {
+	oauthToken.Lock()
+	defer oauthToken.Unlock()
+
+	if oauthToken.value == "" || (!oauthToken.expires.IsZero() && !time.Now().Add(oauthRefreshMargin).Before(oauthToken.expires)) {
+		return "", false
+	}
+	return oauthToken.value, true
+}
+
+// fetchOAuthToken runs the client credentials grant, sending the client id and secret as
+// basic auth. A token without expires_in is kept for the life of the process.
+func fetchOAuthToken() (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequest("POST", *oauthTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.SetBasicAuth(url.QueryEscape(*oauthClientID), url.QueryEscape(*oauthClientSecret))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", cronUserAgent)
+
+	resp, err := portalClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := readBody(resp)
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token endpoint returned %v", resp.Status)
+	}
+
+	var reply struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return "", 0, err
+	}
+	if reply.AccessToken == "" {
+		return "", 0, errors.New("token endpoint returned no access_token")
+	}
+	if reply.TokenType != "" && !strings.EqualFold(reply.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported token_type %q", reply.TokenType)
+	}
+	return reply.AccessToken, time.Duration(reply.ExpiresIn) * time.Second, nil
+}
+
+// oauthSecrets are the OAuth2 values that must never reach the logs
+func oauthSecrets() []string {
+	oauthToken.Lock()
+	defer oauthToken.Unlock()
+	return []string{*oauthClientSecret, oauthToken.value}
+}
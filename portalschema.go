@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+)
+
+var groupByServer = flag.Bool("groupByServer", false, "send the admin portal an object keyed by ServerID and DataType instead of a flat list of results")
+var portalSchemaFile = flag.String("portalSchema", "", "JSON file renaming result fields for the admin portal, e.g. {\"ServerStatus\": \"status\"}")
+
+// portalFields maps TomcatCheckResult field names to the names the admin portal expects.
+// Fields that aren't listed keep their own name.
+var portalFields map[string]string
+
+// loadPortalSchema reads the -portalSchema file, rejecting fields the results don't have
+func loadPortalSchema() error {
+	data, err := ioutil.ReadFile(*portalSchemaFile)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &portalFields); err != nil {
+		return err
+	}
+
+	resultType := reflect.TypeOf(TomcatCheckResult{})
+	for field := range portalFields {
+		if _, ok := resultType.FieldByName(field); !ok {
+			return fmt.Errorf("unknown result field %q", field)
+		}
+	}
+	return nil
+}
+
+// portalPayload serializes results the way the admin portal wants them
+func portalPayload(results []TomcatCheckResult) ([]byte, error) {
+	if len(portalFields) == 0 && !*groupByServer {
+		return json.Marshal(results)
+	}
+
+	// Go through a generic form so omitempty is honored before renaming
+	data, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+	var generic []map[string]interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+
+	renamed := make([]map[string]interface{}, len(generic))
+	for i, result := range generic {
+		renamed[i] = make(map[string]interface{}, len(result))
+		for field, value := range result {
+			renamed[i][portalName(field)] = value
+		}
+	}
+	if !*groupByServer {
+		return json.Marshal(renamed)
+	}
+
+	// The keys already say which server and DataType a result is for
+	grouped := make(map[string]map[string]map[string]interface{})
+	for i, result := range results {
+		fields := renamed[i]
+		delete(fields, portalName("ServerID"))
+		delete(fields, portalName("DataType"))
+
+		if grouped[result.ServerID] == nil {
+			grouped[result.ServerID] = make(map[string]map[string]interface{})
+		}
+		grouped[result.ServerID][result.DataType] = fields
+	}
+	return json.Marshal(grouped)
+}
+
+// portalName is what the admin portal calls a result field
+func portalName(field string) string {
+	if name, ok := portalFields[field]; ok {
+		return name
+	}
+	return field
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var postProcessCommand = flag.String("postProcess", "", "command that gets the portal payload on stdin and prints the payload to send instead")
+var postProcessTimeout = flag.Duration("postProcessTimeout", 10*time.Second, "how long -postProcess may run before the original payload is sent")
+
+// validatePostProcess rejects a -postProcess that's only whitespace, there'd be no command to run
+func validatePostProcess() error {
+	if len(*postProcessCommand) > 0 && len(strings.Fields(*postProcessCommand)) == 0 {
+		return errors.New("-postProcess needs a command")
+	}
+	return nil
+}
+
+// postProcess runs the payload through -postProcess. The command is split on spaces and run
+// without a shell. Whatever goes wrong, a failure, a timeout or output that isn't JSON, the
+// original payload is sent so a broken script can't stop results from reaching the portal.
+func postProcess(payload []byte) []byte {
+	processed, err := runPostProcess(payload)
+	if err != nil {
+		logger.Error("Post-processing failed, sending the original payload: ", err)
+		recordError("postProcess: %v", err)
+		return payload
+	}
+	return processed
+}
+
+func runPostProcess(payload []byte) ([]byte, error) {
+	args := strings.Fields(*postProcessCommand)
+	ctx, cancel := context.WithTimeout(context.Background(), *postProcessTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.New("timed out after " + postProcessTimeout.String())
+		}
+		return nil, errors.New(err.Error() + ": " + strings.TrimSpace(stderr.String()))
+	}
+
+	processed := bytes.TrimSpace(stdout.Bytes())
+	if !json.Valid(processed) {
+		return nil, errors.New("output is not JSON")
+	}
+	return processed, nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func TestValidatePostProcess(t *testing.T) {
+	defer func(command string) { *postProcessCommand = command }(*postProcessCommand)
+
+	for command, valid := range map[string]bool{"": true, "jq .": true, " ": false, "\t \n": false} {
+		*postProcessCommand = command
+		if err := validatePostProcess(); (err == nil) != valid {
+			t.Errorf("-postProcess %q: got %v, valid %v", command, err, valid)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var prewarm = flag.Int("prewarm", 0, "with -interval, open up to this many keep-alive connections each to the Jolokia proxy and the admin portal before the first run, 0 to skip")
+
+// prewarmConnections sends a few HEAD requests to the Jolokia proxy and the admin portal at
+// once, so the first run finds connected, handshaken connections in the pools. No more are
+// opened than a transport keeps idle, the rest would just be closed again. What a HEAD is
+// answered with doesn't matter, and failures are left for the run to report. The HEADs carry
+// the same credentials as the real requests, so they don't show up as unauthenticated hits.
+func prewarmConnections() {
+	targets := []struct {
+		name      string
+		url       string
+		transport http.RoundTripper
+		idle      int
+		auth      func(*http.Request) error
+	}{
+		{"Jolokia", *jolokiaURL, traced(jolokiaTransport), idleConnsPerHost(jolokiaTransport), jolokiaAuth},
+		{"admin portal", adminURL, portalClient.Transport, http.DefaultMaxIdleConnsPerHost, setPortalAuth},
+	}
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		conns := *prewarm
+		if conns > target.idle {
+			conns = target.idle
+		}
+		client := &http.Client{Transport: target.transport, Timeout: 10 * time.Second}
+
+		for i := 0; i < conns; i++ {
+			wg.Add(1)
+			go func(name string, url string, auth func(*http.Request) error) {
+				defer wg.Done()
+				req, err := http.NewRequest("HEAD", url, nil)
+				if err != nil {
+					return
+				}
+				if err := auth(req); err != nil {
+					logger.Debug("Not prewarming ", name, ": ", err)
+					return
+				}
+				req.Header.Set("User-Agent", cronUserAgent)
+				resp, err := client.Do(req)
+				if err != nil {
+					logger.Debug("Could not prewarm a connection to ", name, ": ", redacted(err))
+					return
+				}
+				drainBody(resp.Body)
+			}(target.name, target.url, target.auth)
+		}
+		logger.Debugf("Prewarming %v connections to %v", conns, target.name)
+	}
+	wg.Wait()
+}
+
+// jolokiaAuth adds the -jolokiaHeader headers every Jolokia request carries
+func jolokiaAuth(req *http.Request) error {
+	jolokiaHeaders.apply(req)
+	return nil
+}
+
+// idleConnsPerHost is how many connections a transport keeps open to one host between requests
+func idleConnsPerHost(transport *http.Transport) int {
+	if transport.MaxIdleConnsPerHost > 0 {
+		return transport.MaxIdleConnsPerHost
+	}
+	return http.DefaultMaxIdleConnsPerHost
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// recordingTransport answers every request itself, keeping its headers
+type recordingTransport struct {
+	sync.Mutex
+	headers []http.Header
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.Lock()
+	r.headers = append(r.headers, req.Header.Clone())
+	r.Unlock()
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestPrewarmAuthenticates(t *testing.T) {
+	defer func(conns int, tok string, proxy string, transport http.RoundTripper) {
+		*prewarm, *token, *jolokiaURL, portalClient.Transport = conns, tok, proxy, transport
+	}(*prewarm, *token, *jolokiaURL, portalClient.Transport)
+	defer delete(jolokiaHeaders, "X-Api-Key")
+
+	var mu sync.Mutex
+	var jolokiaKeys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		jolokiaKeys = append(jolokiaKeys, r.Header.Get("X-Api-Key"))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	portal := &recordingTransport{}
+	*prewarm, *token, *jolokiaURL, portalClient.Transport = 2, "t0ken", server.URL, portal
+	jolokiaHeaders.Set("X-API-Key: s3cret")
+
+	prewarmConnections()
+
+	if len(portal.headers) != 2 {
+		t.Fatalf("got %v portal requests, want 2", len(portal.headers))
+	}
+	for _, header := range portal.headers {
+		if header.Get("X-Auth-Token") != "t0ken" {
+			t.Errorf("portal prewarm went out without the token: %v", header)
+		}
+	}
+	if len(jolokiaKeys) != 2 || jolokiaKeys[0] != "s3cret" || jolokiaKeys[1] != "s3cret" {
+		t.Errorf("Jolokia prewarms carried %q, want the -jolokiaHeader on each", jolokiaKeys)
+	}
+}
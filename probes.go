@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var probeOrderFlag = flag.String("probeOrder", "http,jmx", "comma separated order the probes run in, from tcp, http and jmx; http is required")
+var earlyExitOnFail = flag.Bool("earlyExitOnFail", false, "skip the later probes of an instance once one of its probes fails")
+
+func probeOrder() []string {
+	return strings.Split(*probeOrderFlag, ",")
+}
+
+// validateProbeOrder makes sure every probe is known and runs once. The HTTP check is what an
+// instance's up/down status comes from, so it can't be left out.
+func validateProbeOrder() error {
+	seen := make(map[string]bool)
+	for _, probe := range probeOrder() {
+		switch probe {
+		case "tcp", "http", "jmx":
+		default:
+			return fmt.Errorf("unknown probe %q, use tcp, http or jmx", probe)
+		}
+		if seen[probe] {
+			return fmt.Errorf("probe %q is listed twice", probe)
+		}
+		seen[probe] = true
+	}
+	if !seen["http"] {
+		return errors.New("-probeOrder must include http")
+	}
+	return nil
+}
+
+// checkTCP connects to the HTTP port of every instance, the cheapest sign of life there is
+func checkTCP(instances []TomcatInstance) []TomcatCheckResult {
+	tcpResponseChannel := make(chan []TomcatCheckResult, len(instances))
+	for _, instance := range instances {
+		httpTimeout, _ := timeoutsFor(instance)
+		go func(tomcat TomcatInstance, timeout time.Duration) {
+			tcpResponseChannel <- []TomcatCheckResult{probeTCP(tomcat, timeout)}
+		}(instance, httpTimeout)
+	}
+	return waitForDomains(tcpResponseChannel, len(instances))
+}
+
+// probeTCP dials through the check transport's dialer, so -connectTimeout, -dnsServer and SSH
+// tunnels apply just like they do to the HTTP check
+func probeTCP(tomcat TomcatInstance, timeout time.Duration) TomcatCheckResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := checkTransport.DialContext(ctx, "tcp", net.JoinHostPort(tomcat.ServerIP, tomcat.HTTPPort))
+	if err != nil {
+		failed := newCheckResult(tomcat, false, "tcp", "0")
+		failed.FailureReason = classifyHTTPError(err)
+		return failed
+	}
+	conn.Close()
+	return newCheckResult(tomcat, true, "tcp", formatElapsed(time.Since(start)))
+}
+
+// probeFailed tells whether a result is a failure of the given probe
+func probeFailed(probe string, result TomcatCheckResult) bool {
+	switch probe {
+	case "tcp":
+		return result.DataType == "tcp" && !result.ServerStatus
+	case "http":
+		return result.DataType == "time" && !result.ServerStatus
+	case "jmx":
+		return isJmxFailure(result)
+	}
+	return false
+}
+
+// dropFailedInstances removes the instances whose probe failed from the ones still to be
+// probed. An instance dropped before its HTTP check gets a failed time result in its place so
+// it's still reported down.
+func dropFailedInstances(instances []TomcatInstance, results []TomcatCheckResult, probe string) (remaining []TomcatInstance, failed []TomcatCheckResult) {
+	reasons := make(map[string]string)
+	for _, result := range results {
+		if probeFailed(probe, result) {
+			reasons[result.ServerID] = probe + ": " + result.FailureReason
+		}
+	}
+
+	httpDone := false
+	for _, p := range probeOrder() {
+		if p == "http" {
+			httpDone = true
+		}
+		if p == probe {
+			break
+		}
+	}
+
+	for _, tomcat := range instances {
+		reason, ok := reasons[tomcat.ServerID]
+		if !ok {
+			remaining = append(remaining, tomcat)
+			continue
+		}
+		if !httpDone {
+			timeResult := newCheckResult(tomcat, false, "time", "0")
+			timeResult.FailureReason = reason
+			failed = append(failed, timeResult)
+		}
+	}
+	return remaining, failed
+}
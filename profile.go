@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+)
+
+var cpuProfile = flag.String("cpuprofile", "", "write a CPU profile of the run to this file")
+var memProfile = flag.String("memprofile", "", "write a heap profile to this file after the portal update")
+
+// stopCPUProfile flushes the CPU profile. Besides main's defer, everything that exits with
+// os.Exit calls it, or a killed -interval daemon would leave an empty profile behind.
+var stopCPUProfile = func() {}
+
+// startCPUProfile starts profiling when -cpuprofile is set
+func startCPUProfile() {
+	if *cpuProfile == "" {
+		return
+	}
+
+	f, err := os.Create(*cpuProfile)
+	if err != nil {
+		logger.Error("Could not create CPU profile", err)
+		return
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		logger.Error("Could not start CPU profile", err)
+		f.Close()
+		return
+	}
+
+	var once sync.Once
+	stopCPUProfile = func() {
+		once.Do(func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		})
+	}
+}
+
+// writeHeapProfile writes the heap profile when -memprofile is set
+func writeHeapProfile() {
+	if *memProfile == "" {
+		return
+	}
+
+	f, err := os.Create(*memProfile)
+	if err != nil {
+		logger.Error("Could not create heap profile", err)
+		return
+	}
+	defer f.Close()
+
+	// Get up-to-date statistics
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		logger.Error("Could not write heap profile", err)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStopCPUProfileTwice(t *testing.T) {
+	defer func(path string, stop func()) { *cpuProfile, stopCPUProfile = path, stop }(*cpuProfile, stopCPUProfile)
+	*cpuProfile = filepath.Join(t.TempDir(), "cpu.prof")
+
+	startCPUProfile()
+	// The shutdown path stops it before os.Exit, then main's defer may run too
+	stopCPUProfile()
+	stopCPUProfile()
+
+	info, err := os.Stat(*cpuProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() == 0 {
+		t.Error("the CPU profile wasn't flushed")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var pushgatewayURL = flag.String("pushgateway", "", "Prometheus Pushgateway URL to push numeric results to")
+var pushgatewayJob = flag.String("pushgatewayJob", "jmx_cron", "job label used when pushing to the Pushgateway")
+
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// resultValue parses the ServerResponse of a check result as a number
+func resultValue(result TomcatCheckResult) (float64, bool) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(result.ServerResponse), 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// resultInt parses the ServerResponse of a check result as a whole number, exactly, for
+// counters too large to survive a float64
+func resultInt(result TomcatCheckResult) (int64, bool) {
+	v, err := strconv.ParseInt(strings.TrimSpace(result.ServerResponse), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// prometheusMetricName turns a DataType into a valid Prometheus metric name
+func prometheusMetricName(dataType string) string {
+	return "jmxcron_" + invalidMetricChars.ReplaceAllString(dataType, "_")
+}
+
+// prometheusText serializes the numeric results in the Prometheus text exposition format.
+// The server_id label can be left off when the Pushgateway grouping key already identifies it.
+func prometheusText(results []TomcatCheckResult, serverLabel bool) []byte {
+	byMetric := make(map[string][]TomcatCheckResult)
+	for _, result := range results {
+		if _, ok := resultValue(result); !ok {
+			continue
+		}
+		name := prometheusMetricName(result.DataType)
+		byMetric[name] = append(byMetric[name], result)
+	}
+
+	names := make([]string, 0, len(byMetric))
+	for name := range byMetric {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", name)
+		for _, result := range byMetric[name] {
+			v, _ := resultValue(result)
+			buf.WriteString(name)
+			if serverLabel {
+				buf.WriteString(`{server_id="` + prometheusEscape(result.ServerID) + `"}`)
+			}
+			buf.WriteString(" ")
+			buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+			buf.WriteString("\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+func prometheusEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// pushToGateway PUTs the numeric results to the Pushgateway, grouped by instance. Errors are
+// logged and never stop the run, the admin portal is still the source of truth.
+func pushToGateway(results []TomcatCheckResult) {
+	byServer := make(map[string][]TomcatCheckResult)
+	for _, result := range results {
+		byServer[result.ServerID] = append(byServer[result.ServerID], result)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	base := strings.TrimRight(*pushgatewayURL, "/") + "/metrics/job/" + url.PathEscape(*pushgatewayJob)
+
+	for serverID, serverResults := range byServer {
+		body := prometheusText(serverResults, false)
+		if len(body) == 0 {
+			continue
+		}
+
+		req, err := http.NewRequest("PUT", base+"/instance/"+url.PathEscape(serverID), bytes.NewReader(body))
+		if err != nil {
+			logger.Error("Could not build pushgateway request", err)
+			return
+		}
+		req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+		req.Header.Set("User-Agent", cronUserAgent)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			logger.Error("Could not push to pushgateway", serverID, err)
+			continue
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			logger.Errorf("Bad pushgateway response for %v: %v", serverID, resp.Status)
+		}
+	}
+}
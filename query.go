@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var queryMbean = flag.String("query", "", "print one attribute of the first instance, e.g. -query java.lang:type=Memory HeapMemoryUsage [used], then exit")
+
+// jolokiaEscaper escapes the parts of a Jolokia GET URL, where / separates the parts and ! escapes
+var jolokiaEscaper = strings.NewReplacer("!", "!!", "/", "!/")
+
+// jolokiaReadURL builds the GET URL reading one attribute, the path's own slashes walk into
+// the value as they do in a POST
+func jolokiaReadURL(endpoint string, mbean string, attribute string, path string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	readPath := strings.TrimRight(u.Path, "/") + "/read/" + jolokiaEscaper.Replace(mbean) + "/" + jolokiaEscaper.Replace(attribute)
+	if path != "" {
+		readPath += "/" + path
+	}
+	u.Path = readPath
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// getJolokia reads one attribute with a GET, which some Jolokia setups allow when POST is
+// locked down. A GET answers with a single response instead of a list.
+func getJolokia(readURL string, timeout time.Duration) (JolokiaRequestResponse, error) {
+	respJ := make(JolokiaRequestResponse, 1)
+
+	client := &http.Client{
+		Transport: traced(jolokiaTransport),
+		Timeout:   timeout,
+	}
+	req, err := http.NewRequest("GET", readURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", cronUserAgent)
+	jolokiaHeaders.apply(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	contents, err := ioutil.ReadAll(io.LimitReader(resp.Body, *maxJmxBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(contents)) > *maxJmxBody {
+		return nil, errResponseTooLarge
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(contents))
+	dec.UseNumber()
+	if err := dec.Decode(&respJ[0]); err != nil {
+		return nil, err
+	}
+	return respJ, nil
+}
+
+// readAttribute reads one attribute of an instance. An agent on the instance is read with a
+// GET, a proxy needs the target in a POST body.
+func readAttribute(tomcat TomcatInstance, mbean string, attribute string, path string, timeout time.Duration) (interface{}, error) {
+	endpoint, jmxURL := jolokiaEndpoint(tomcat)
+
+	var respJ JolokiaRequestResponse
+	var err error
+	if jmxURL == "" {
+		var readURL string
+		if readURL, err = jolokiaReadURL(endpoint, mbean, attribute, path); err != nil {
+			return nil, err
+		}
+		respJ, err = getJolokia(readURL, timeout)
+	} else {
+		metric := JmxMetric{Mbean: mbean, Attribute: attribute, Path: path}
+		respJ, err = postJolokia(endpoint, []JolokiaRequest{metric.request(jmxURL)}, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(respJ) != 1 {
+		return nil, errors.New("no read response")
+	}
+	if respJ[0].Status != http.StatusOK {
+		return nil, fmt.Errorf("read failed: %v", respJ[0].Error)
+	}
+	return respJ[0].Value, nil
+}
+
+// query prints the attribute named by -query and the arguments on the first instance, to
+// try out a read before putting it in a -metrics file
+func query() {
+	args := flag.Args()
+	if len(args) < 1 || len(args) > 2 {
+		fatalf("Usage: -query <mbean> <attribute> [path]")
+	}
+	attribute, path := args[0], ""
+	if len(args) == 2 {
+		path = args[1]
+	}
+
+	instances, err := getInstancesFromPortal()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if len(instances) == 0 {
+		fatalf("No instances to query")
+	}
+
+	tomcat := instances[0]
+	_, timeout := timeoutsFor(tomcat)
+	value, err := readAttribute(tomcat, *queryMbean, attribute, path, timeout)
+	if err != nil {
+		fatalf("Could not read %v on %v: %v", attribute, tomcat.ServerID, err)
+	}
+
+	name := *queryMbean + " " + attribute
+	if path != "" {
+		name += " " + path
+	}
+	out, _ := json.MarshalIndent(value, "", "  ")
+	fmt.Printf("%v on %v:\n%s\n", name, tomcat.ServerID, out)
+}
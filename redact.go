@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var redact = flag.Bool("redact", false, "mask the last octet of IP addresses in the logs, for sharing them in support tickets")
+
+// ipv4 matches an address and captures its /24
+var ipv4 = regexp.MustCompile(`\b(\d{1,3}\.\d{1,3}\.\d{1,3})\.\d{1,3}\b`)
+
+// redactLog cleans up text on its way into the logs. The token and OAuth2 secrets never make
+// it in, with or without -redact, and -redact masks addresses down to their /24.
+func redactLog(s string) string {
+	for _, secret := range append(oauthSecrets(), *token) {
+		if secret != "" {
+			s = strings.Replace(s, secret, "REDACTED", -1)
+		}
+	}
+	if *redact {
+		s = ipv4.ReplaceAllString(s, "${1}.x")
+	}
+	return s
+}
+
+// redacted formats any value for the logs through redactLog
+func redacted(v interface{}) string {
+	return redactLog(fmt.Sprint(v))
+}
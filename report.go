@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var reportFile = flag.String("report", "", "write a JSON report of the run to this file")
+
+// RunReport is the -report file, meant for local log shipping and post-mortems
+type RunReport struct {
+	Version    string
+	Started    time.Time
+	DurationMs int64
+	Flags      map[string]string
+	Total      int
+	Up         int
+	Down       int
+	Results    []TomcatCheckResult
+	Errors     []string
+}
+
+var report = struct {
+	sync.Mutex
+	started   time.Time
+	instances []TomcatInstance
+	results   []TomcatCheckResult
+	errors    []string
+}{started: time.Now()}
+
+// startReport forgets the previous run, in -interval mode every run gets its own report
+func startReport() {
+	report.Lock()
+	defer report.Unlock()
+	report.started = time.Now()
+	report.instances = nil
+	report.results = nil
+	report.errors = nil
+}
+
+// recordError notes a problem for the report, it doesn't log it
+func recordError(format string, args ...interface{}) {
+	report.Lock()
+	defer report.Unlock()
+	report.errors = append(report.errors, fmt.Sprintf(format, args...))
+}
+
+// recordResults keeps the collected results for the report
+func recordResults(instances []TomcatInstance, results []TomcatCheckResult) {
+	report.Lock()
+	defer report.Unlock()
+	report.instances = instances
+	report.results = results
+}
+
+// fatalf logs, writes whatever report we have and exits
+func fatalf(format string, args ...interface{}) {
+	msg := redactLog(fmt.Sprintf(format, args...))
+	logger.Alert(msg)
+	recordError("%v", msg)
+	writeReport()
+	stopCPUProfile()
+	os.Exit(1)
+}
+
+// writeReport writes the -report file when it's configured
+func writeReport() {
+	if *reportFile == "" {
+		return
+	}
+
+	report.Lock()
+	defer report.Unlock()
+
+	runReport := RunReport{
+		Version:    version,
+		Started:    report.started,
+		DurationMs: int64(time.Since(report.started) / time.Millisecond),
+		Flags:      reportFlags(),
+		Total:      len(report.instances),
+		Results:    report.results,
+		Errors:     report.errors,
+	}
+	for _, result := range instanceStatuses(report.results) {
+		if result.ServerStatus {
+			runReport.Up++
+		} else {
+			runReport.Down++
+		}
+	}
+
+	data, err := json.MarshalIndent(runReport, "", "  ")
+	if err != nil {
+		logger.Error("Could not marshal report", err)
+		return
+	}
+	if err := ioutil.WriteFile(*reportFile, data, 0644); err != nil {
+		logger.Error("Could not write report", err)
+	}
+}
+
+// redactedFlags never make it into the report
+var redactedFlags = map[string]bool{"token": true, "oauthClientSecret": true}
+
+func reportFlags() map[string]string {
+	flags := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = redactFlag(f)
+	})
+	return flags
+}
+
+// redactFlag hides secrets in a flag's value. Header flags keep their names so the report still
+// shows what was sent, and any URL loses its userinfo.
+func redactFlag(f *flag.Flag) string {
+	value := f.Value.String()
+	if value == "" {
+		return value
+	}
+	if redactedFlags[f.Name] {
+		return "REDACTED"
+	}
+	if headers, ok := f.Value.(headerFlags); ok {
+		var pairs []string
+		for name, values := range headers {
+			for range values {
+				pairs = append(pairs, name+": REDACTED")
+			}
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ", ")
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		u.User = url.User("REDACTED")
+		return u.String()
+	}
+	return value
+}
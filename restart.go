@@ -0,0 +1,33 @@
+package main
+
+// detectRestarts reports restarted as 1 for every instance whose JVM uptime went down since the
+// previous run, and 0 otherwise, including the first run an instance is seen. Call it after
+// update. An instance whose uptime couldn't be read keeps the last uptime for the next run.
+func (s *RunState) detectRestarts(instances []TomcatInstance, results []TomcatCheckResult) []TomcatCheckResult {
+	uptimes := make(map[string]int64)
+	for _, result := range results {
+		if result.DataType != "uptime" {
+			continue
+		}
+		if v, ok := resultInt(result); ok {
+			uptimes[result.ServerID] = v
+		}
+	}
+
+	for _, tomcat := range instances {
+		state, ok := s.Instances[tomcat.ServerID]
+		uptime, read := uptimes[tomcat.ServerID]
+		if !ok || !read {
+			continue
+		}
+
+		restarted := "0"
+		if state.Uptime > 0 && uptime < state.Uptime {
+			logger.Warningf("%v restarted, uptime went from %vms to %vms", tomcat.ServerID, state.Uptime, uptime)
+			restarted = "1"
+		}
+		results = append(results, newCheckResult(tomcat, true, "restarted", restarted))
+		state.Uptime = uptime
+	}
+	return results
+}
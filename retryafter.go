@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var portalRetries = flag.Int("portalRetries", 2, "times to retry the admin portal when it answers 429 or 503")
+var maxRetryAfter = flag.Duration("maxRetryAfter", time.Minute, "longest admin portal Retry-After that is honored, longer ones are cut to this")
+
+// doPortal sends a request to the admin portal, retrying a throttled (429) or unavailable (503)
+// answer after its Retry-After, or the usual backoff when it doesn't give one. newRequest is
+// called for every attempt because a request body can only be sent once.
+func doPortal(newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := portalClient.Do(req)
+		if err != nil || attempt > *portalRetries || !retryableStatus(resp.StatusCode) {
+			return resp, err
+		}
+
+		delay, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !ok {
+			delay = time.Duration(attempt) * 2 * time.Second
+		}
+		if delay > *maxRetryAfter {
+			delay = *maxRetryAfter
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		logger.Warningf("Admin portal answered %v, retry %v of %v in %v", resp.Status, attempt, *portalRetries, delay)
+		time.Sleep(delay)
+	}
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// retryAfter parses a Retry-After header, which is either a number of seconds or an HTTP date
+func retryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(header); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
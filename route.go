@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// routeTracker records the JVM route of the JSESSIONID cookies an instance hands out. Behind
+// a load balancer with sticky sessions the route must be the instance's own JvmRoute, or
+// sessions end up pinned to the wrong JVM.
+type routeTracker struct {
+	sync.Mutex
+	transport http.RoundTripper
+	routes    []string
+}
+
+func (t *routeTracker) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name != "JSESSIONID" {
+			continue
+		}
+		if route := sessionRoute(cookie.Value); route != "" {
+			t.Lock()
+			t.routes = append(t.routes, route)
+			t.Unlock()
+		}
+	}
+	return resp, err
+}
+
+// sessionRoute is what Tomcat appends to the session id after the last dot, empty without one
+func sessionRoute(sessionID string) string {
+	i := strings.LastIndex(sessionID, ".")
+	if i < 0 {
+		return ""
+	}
+	return sessionID[i+1:]
+}
+
+// result reports route_mismatch, with the route that was seen, when a session cookie carried
+// another instance's route. Instances without a JvmRoute, and responses that didn't start a
+// session, have nothing to compare.
+func (t *routeTracker) result(tomcat TomcatInstance) (TomcatCheckResult, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	if tomcat.JvmRoute == "" {
+		return TomcatCheckResult{}, false
+	}
+	for _, route := range t.routes {
+		if route != tomcat.JvmRoute {
+			result := newCheckResult(tomcat, false, "route_mismatch", route)
+			result.FailureReason = "session route " + route + ", expected " + tomcat.JvmRoute
+			return result, true
+		}
+	}
+	return TomcatCheckResult{}, false
+}
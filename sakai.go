@@ -0,0 +1,24 @@
+package main
+
+import "strconv"
+
+// cacheHitRatio adds cache_hit_ratio, the percentage of Sakai memory cache lookups that hit,
+// when both counters were read and there has been at least one lookup
+func cacheHitRatio(tomcat TomcatInstance, results []TomcatCheckResult) []TomcatCheckResult {
+	var hits, misses float64
+	var haveHits, haveMisses bool
+	for _, result := range results {
+		switch result.DataType {
+		case "cache_hits":
+			hits, haveHits = resultValue(result)
+		case "cache_misses":
+			misses, haveMisses = resultValue(result)
+		}
+	}
+
+	if !haveHits || !haveMisses || hits+misses == 0 {
+		return nil
+	}
+	ratio := strconv.FormatFloat(hits/(hits+misses)*100, 'f', 2, 64)
+	return []TomcatCheckResult{newCheckResult(tomcat, true, "cache_hit_ratio", ratio)}
+}
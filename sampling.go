@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"math/rand"
+	"time"
+)
+
+var sampleProjects = flag.Bool("sampleProjects", false, "only check one instance per ProjectID for a quick health snapshot")
+var sampleRandom = flag.Bool("sampleRandom", false, "with -sampleProjects pick a random instance of each project instead of the first")
+
+// sampleByProject keeps one instance of every ProjectID and returns the ones skipped
+func sampleByProject(instances []TomcatInstance) (sampled []TomcatInstance, skipped []TomcatInstance) {
+	byProject := make(map[string][]TomcatInstance)
+	var order []string
+	for _, tomcat := range instances {
+		if _, seen := byProject[tomcat.ProjectID]; !seen {
+			order = append(order, tomcat.ProjectID)
+		}
+		byProject[tomcat.ProjectID] = append(byProject[tomcat.ProjectID], tomcat)
+	}
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for _, projectID := range order {
+		candidates := byProject[projectID]
+		pick := 0
+		if *sampleRandom {
+			pick = random.Intn(len(candidates))
+		}
+
+		for i, tomcat := range candidates {
+			if i == pick {
+				sampled = append(sampled, tomcat)
+			} else {
+				skipped = append(skipped, tomcat)
+			}
+		}
+	}
+	return sampled, skipped
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+var sessionCapsFile = flag.String("sessionCaps", "", "JSON file mapping ProjectName or ProjectID to its session cap, for webapps that don't set maxActiveSessions")
+
+var sessionCaps map[string]int
+
+// loadSessionCaps reads the -sessionCaps file
+func loadSessionCaps() error {
+	data, err := ioutil.ReadFile(*sessionCapsFile)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &sessionCaps)
+}
+
+// sessionCap is the most sessions the instance takes. The tightest maxActiveSessions of its
+// webapps wins, -1 means unlimited, then the -sessionCaps entry of its project.
+func sessionCap(tomcat TomcatInstance, results []TomcatCheckResult) (float64, bool) {
+	var limit float64
+	found := false
+	for _, result := range results {
+		if !strings.HasPrefix(result.DataType, "sessions_max_") {
+			continue
+		}
+		if v, ok := resultValue(result); ok && v > 0 && (!found || v < limit) {
+			limit, found = v, true
+		}
+	}
+	if found {
+		return limit, true
+	}
+
+	configured, ok := sessionCaps[tomcat.ProjectID]
+	if !ok {
+		configured, ok = sessionCaps[tomcat.ProjectName]
+	}
+	if !ok || configured <= 0 {
+		return 0, false
+	}
+	return float64(configured), true
+}
+
+// sessionUtilization adds session_utilization_percent, Sakai's active sessions as a
+// percentage of the cap, when a cap is known. Otherwise the sessions count stands alone.
+func sessionUtilization(tomcat TomcatInstance, results []TomcatCheckResult) []TomcatCheckResult {
+	var active float64
+	haveActive := false
+	for _, result := range results {
+		if result.DataType == "sessions" {
+			active, haveActive = resultValue(result)
+		}
+	}
+
+	limit, ok := sessionCap(tomcat, results)
+	if !haveActive || !ok {
+		return nil
+	}
+	percent := strconv.FormatFloat(active/limit*100, 'f', 2, 64)
+	return []TomcatCheckResult{newCheckResult(tomcat, true, "session_utilization_percent", percent)}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// partial holds the results of the current run as the checks finish, so a run that's killed
+// halfway can still report what it collected
+var partial = struct {
+	sync.Mutex
+	results   []TomcatCheckResult
+	reporting bool
+	reported  chan struct{}
+}{reported: make(chan struct{})}
+
+// resetPartial starts collecting a new run
+func resetPartial() {
+	partial.Lock()
+	defer partial.Unlock()
+	partial.results = nil
+	partial.reporting = false
+	partial.reported = make(chan struct{})
+}
+
+// collectPartial keeps the results of one finished check
+func collectPartial(results []TomcatCheckResult) {
+	partial.Lock()
+	defer partial.Unlock()
+	if !partial.reporting {
+		partial.results = append(partial.results, results...)
+	}
+}
+
+// startReporting hands the results over to the normal reporting, a signal from here on waits
+// for it instead of reporting the partial results a second time
+func startReporting() {
+	partial.Lock()
+	defer partial.Unlock()
+	partial.reporting = true
+}
+
+// doneReporting tells a waiting signal handler the results are in
+func doneReporting() {
+	partial.Lock()
+	defer partial.Unlock()
+	close(partial.reported)
+}
+
+// flushOnSignal reports the results collected so far, with a heartbeat, when the run gets a
+// SIGTERM or SIGINT. They aren't run through the state file, the next full run does that.
+func flushOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		sig := <-signals
+
+		partial.Lock()
+		if partial.reporting {
+			reported := partial.reported
+			partial.Unlock()
+			logger.Warning("Got ", sig, ", letting the report in progress finish")
+			<-reported
+			flushKafka()
+			stopCPUProfile()
+			os.Exit(0)
+		}
+		// Keep the lock so finishing checks can't add to what's being sent
+		defer partial.Unlock()
+
+		logger.Warningf("Got %v, reporting the %v results collected so far", sig, len(partial.results))
+		recordError("interrupted by %v", sig)
+		results := append(partial.results, heartbeatResult())
+		recordResults(nil, results)
+		if err := updateAdminPortal(results); err != nil {
+			logger.Error(redactLog(err.Error()))
+		}
+		writeReport()
+		flushKafka()
+		stopCPUProfile()
+		os.Exit(1)
+	}()
+}
@@ -0,0 +1,35 @@
+package main
+
+// skippedResults tells the portal which instances weren't checked and why, so it can tell
+// them apart from instances that are down
+func skippedResults(instances []TomcatInstance, reason string) []TomcatCheckResult {
+	var results []TomcatCheckResult
+	for _, tomcat := range instances {
+		results = append(results, newCheckResult(tomcat, true, "skipped", reason))
+	}
+	return results
+}
+
+// splitCheckable sets aside the instances the portal returned without an address or HTTP port,
+// checking them would only report them down
+func splitCheckable(instances []TomcatInstance) (checkable []TomcatInstance, missing []TomcatInstance) {
+	for _, tomcat := range instances {
+		if tomcat.ServerIP == "" || tomcat.HTTPPort == "" {
+			missing = append(missing, tomcat)
+			continue
+		}
+		checkable = append(checkable, tomcat)
+	}
+	return checkable, missing
+}
+
+// countSkipped counts the skipped results
+func countSkipped(results []TomcatCheckResult) int {
+	skipped := 0
+	for _, result := range results {
+		if result.DataType == "skipped" {
+			skipped++
+		}
+	}
+	return skipped
+}
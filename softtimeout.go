@@ -0,0 +1,19 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+var softTimeout = flag.Duration("softTimeout", 0, "report checks that succeed but take longer than this as degraded, 0 to disable")
+
+// isDegraded tells whether a check that finished within its hard timeout was slower than
+// -softTimeout. A soft timeout at or past the hard timeout could never fire, so it's ignored.
+func isDegraded(elapsed time.Duration, timeout time.Duration) bool {
+	return *softTimeout > 0 && *softTimeout < timeout && elapsed > *softTimeout
+}
+
+// degradedResult marks an instance that answered, but slowly
+func degradedResult(tomcat TomcatInstance, dataType string) TomcatCheckResult {
+	return newCheckResult(tomcat, true, dataType, "1")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"time"
+)
+
+var startupSplay = flag.Duration("startupSplay", 0, "sleep a random time up to this long before starting, spreads out hosts running at the same minute")
+var splayByHost = flag.Bool("splayByHost", false, "derive the splay from the hostname so each host always waits the same amount")
+
+// sleepSplay waits a random part of -startupSplay before the run starts
+func sleepSplay() {
+	if *startupSplay <= 0 {
+		return
+	}
+
+	seed := time.Now().UnixNano()
+	if *splayByHost {
+		if hostname, err := os.Hostname(); err == nil {
+			h := fnv.New64a()
+			h.Write([]byte(hostname))
+			seed = int64(h.Sum64())
+		}
+	}
+
+	splay := time.Duration(rand.New(rand.NewSource(seed)).Int63n(int64(*startupSplay)))
+	logger.Infof("Sleeping %v of startup splay (max %v, seed %v)", splay, *startupSplay, seed)
+	time.Sleep(splay)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var sshTunnel = flag.String("sshTunnel", "", "user@host[:port] of an SSH jump host that instances are reached through")
+var sshTunnelsFile = flag.String("sshTunnels", "", "JSON file mapping Datacenter to its user@host[:port] jump host, \"\" for direct, overrides -sshTunnel")
+var sshKey = flag.String("sshKey", "", "private key for the jump hosts, defaults to ~/.ssh/id_rsa")
+var sshKnownHosts = flag.String("sshKnownHosts", "", "known_hosts file the jump host keys are checked against, defaults to ~/.ssh/known_hosts")
+
+// datacenterTunnels is the -sshTunnels file
+var datacenterTunnels map[string]string
+
+// loadSSHTunnels reads -sshTunnels and checks every jump host is well formed
+func loadSSHTunnels() error {
+	if len(*sshTunnelsFile) > 0 {
+		data, err := ioutil.ReadFile(*sshTunnelsFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &datacenterTunnels); err != nil {
+			return err
+		}
+	}
+
+	tunnels := []string{*sshTunnel}
+	for _, tunnel := range datacenterTunnels {
+		tunnels = append(tunnels, tunnel)
+	}
+	for _, tunnel := range tunnels {
+		if tunnel == "" {
+			continue
+		}
+		if _, _, err := parseTunnel(tunnel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tunnelsConfigured() bool {
+	return len(*sshTunnel) > 0 || len(datacenterTunnels) > 0
+}
+
+// tunnelFor returns the jump host of a datacenter, "" to connect directly
+func tunnelFor(datacenter string) string {
+	if tunnel, ok := datacenterTunnels[datacenter]; ok {
+		return tunnel
+	}
+	return *sshTunnel
+}
+
+// parseTunnel splits user@host[:port], the port defaults to 22
+func parseTunnel(tunnel string) (user string, addr string, err error) {
+	i := strings.LastIndex(tunnel, "@")
+	if i < 1 || i == len(tunnel)-1 {
+		return "", "", fmt.Errorf("SSH tunnel %q is not user@host", tunnel)
+	}
+	user, addr = tunnel[:i], tunnel[i+1:]
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	return user, addr, nil
+}
+
+// tunnelHosts maps the hosts of instances behind a jump host to that jump host
+var tunnelHosts = struct {
+	sync.RWMutex
+	hosts map[string]string
+}{hosts: make(map[string]string)}
+
+// registerTunnels remembers which jump host each instance's HTTP port and Jolokia agent are
+// reached through. JMX reads through the -jolokia proxy are made by the proxy itself, it has
+// to be able to reach the JMX ports on its own.
+func registerTunnels(instances []TomcatInstance) {
+	tunnelHosts.Lock()
+	defer tunnelHosts.Unlock()
+
+	for _, tomcat := range instances {
+		tunnel := tunnelFor(tomcat.Datacenter)
+		if tunnel == "" {
+			continue
+		}
+		tunnelHosts.hosts[tomcat.ServerIP] = tunnel
+		if u, err := url.Parse(tomcat.JolokiaURL); err == nil && len(tomcat.JolokiaURL) > 0 {
+			tunnelHosts.hosts[u.Hostname()] = tunnel
+		}
+	}
+}
+
+func lookupTunnel(host string) string {
+	tunnelHosts.RLock()
+	defer tunnelHosts.RUnlock()
+	return tunnelHosts.hosts[host]
+}
+
+// sshClients holds one connection per jump host, shared by every check going through it
+var sshClients = struct {
+	sync.Mutex
+	clients map[string]*ssh.Client
+}{clients: make(map[string]*ssh.Client)}
+
+// sshClient returns the connection to a jump host, connecting the first time
+func sshClient(tunnel string) (*ssh.Client, error) {
+	sshClients.Lock()
+	defer sshClients.Unlock()
+
+	if client, ok := sshClients.clients[tunnel]; ok {
+		return client, nil
+	}
+
+	user, addr, err := parseTunnel(tunnel)
+	if err != nil {
+		return nil, err
+	}
+	config, err := sshConfig(user)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("SSH tunnel %v: %v", tunnel, err)
+	}
+	logger.Debug("Connected SSH tunnel ", tunnel)
+	sshClients.clients[tunnel] = client
+	return client, nil
+}
+
+// dropSSHClient forgets a jump host connection that stopped working
+func dropSSHClient(tunnel string, client *ssh.Client) {
+	sshClients.Lock()
+	defer sshClients.Unlock()
+
+	if sshClients.clients[tunnel] == client {
+		delete(sshClients.clients, tunnel)
+		client.Close()
+	}
+}
+
+// sshConfig authenticates with -sshKey and only trusts jump hosts listed in -sshKnownHosts
+func sshConfig(user string) (*ssh.ClientConfig, error) {
+	home, _ := os.UserHomeDir()
+
+	keyFile := *sshKey
+	if keyFile == "" {
+		keyFile = filepath.Join(home, ".ssh", "id_rsa")
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("SSH key %v: %v", keyFile, err)
+	}
+
+	knownHostsFile := *sshKnownHosts
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := *connectTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	}, nil
+}
+
+// tunnelDial sends connections to hosts behind a jump host through it and the rest to dial
+func tunnelDial(dial func(ctx context.Context, network string, addr string) (net.Conn, error)) func(ctx context.Context, network string, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+		tunnel := lookupTunnel(host)
+		if tunnel == "" {
+			return dial(ctx, network, addr)
+		}
+
+		client, err := sshClient(tunnel)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := client.Dial(network, addr)
+		if err != nil {
+			// The jump host connection may have dropped since the last check, reconnect once
+			dropSSHClient(tunnel, client)
+			if client, err = sshClient(tunnel); err != nil {
+				return nil, err
+			}
+			conn, err = client.Dial(network, addr)
+		}
+		return conn, err
+	}
+}
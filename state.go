@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+var stateFile = flag.String("stateFile", "", "file used to remember instance state between runs")
+var instanceDropThreshold = flag.Float64("instanceDropThreshold", 50, "abort when the admin portal returns this many percent fewer instances than the last run (needs -stateFile), 0 to disable")
+var proceedOnInstanceDrop = flag.Bool("proceedOnInstanceDrop", false, "only warn about an -instanceDropThreshold drop instead of aborting")
+var failuresToAlert = flag.Int("failuresToAlert", 1, "consecutive failed runs before an instance is reported down (needs -stateFile)")
+
+// InstanceState is what we remember about one instance between runs
+type InstanceState struct {
+	Up                  bool
+	LastChange          int64
+	LastChecked         int64
+	ConsecutiveFailures int
+	GCTime              int64 // summed CollectionTime of all collectors, ms
+	GCTimeAt            int64 // when GCTime was read, unix ms
+	Uptime              int64 // JVM uptime at the last run, ms
+}
+
+// RunState is persisted to the state file at the end of every run
+type RunState struct {
+	Instances     map[string]*InstanceState
+	InstanceCount int // instances the admin portal returned
+	Runs          int // runs so far, rotates -expensiveMetricSample
+}
+
+// Transition is an instance that went up->down or down->up since the last run
+type Transition struct {
+	Instance TomcatInstance
+	Up       bool
+	Reason   string
+}
+
+// loadState reads the previous run's state. A missing or unreadable file just means
+// we start fresh, so it never fails the run.
+func loadState() *RunState {
+	state := &RunState{Instances: make(map[string]*InstanceState)}
+
+	data, err := ioutil.ReadFile(*stateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error("Could not read state file", err)
+		}
+		return state
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		logger.Error("Could not parse state file, starting fresh", err)
+		return &RunState{Instances: make(map[string]*InstanceState)}
+	}
+	if state.Instances == nil {
+		state.Instances = make(map[string]*InstanceState)
+	}
+	return state
+}
+
+// save writes the state next to the real file first so a crash can't leave it half written
+func (s *RunState) save() {
+	data, err := json.Marshal(s)
+	if err != nil {
+		logger.Error("Could not marshal state", err)
+		return
+	}
+
+	tmp := *stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		logger.Error("Could not write state file", err)
+		return
+	}
+	if err := os.Rename(tmp, *stateFile); err != nil {
+		logger.Error("Could not replace state file", err)
+	}
+}
+
+// checkInstanceDrop compares the number of instances the admin portal returned to the last
+// run's. A big drop is more likely a partial load on the portal's side than that many instances
+// being decommissioned at once, and carrying on would report the missing ones as gone.
+func (s *RunState) checkInstanceDrop(count int) error {
+	previous := s.InstanceCount
+	s.InstanceCount = count
+	if *instanceDropThreshold <= 0 || previous == 0 || count >= previous {
+		return nil
+	}
+
+	drop := float64(previous-count) / float64(previous) * 100
+	if drop <= *instanceDropThreshold {
+		return nil
+	}
+	if !*proceedOnInstanceDrop {
+		return fmt.Errorf("Admin portal returned %v instances, %.0f%% fewer than the %v of the last run", count, drop, previous)
+	}
+	logger.Errorf("Admin portal returned %v instances, %.0f%% fewer than the %v of the last run, carrying on", count, drop, previous)
+	recordError("instance count dropped from %v to %v", previous, count)
+	return nil
+}
+
+// update records this run's overall status for every instance and returns the ones whose
+// status changed since the previous run. Instances seen for the first time aren't transitions.
+// An instance only counts as down once it failed -failuresToAlert runs in a row. The unchecked
+// instances, sampled out or missing an address, keep what was known about them.
+func (s *RunState) update(instances []TomcatInstance, unchecked []TomcatInstance, results []TomcatCheckResult) []Transition {
+	var transitions []Transition
+	now := time.Now().Unix()
+	statuses := instanceStatuses(results)
+	current := make(map[string]*InstanceState)
+
+	for _, tomcat := range instances {
+		result, checked := statuses[tomcat.ServerID]
+		if !checked {
+			continue
+		}
+
+		previous, known := s.Instances[tomcat.ServerID]
+		failures := 0
+		if known && !result.ServerStatus {
+			failures = previous.ConsecutiveFailures + 1
+		} else if !result.ServerStatus {
+			failures = 1
+		}
+		up := failures < *failuresToAlert
+
+		if !known {
+			current[tomcat.ServerID] = &InstanceState{Up: up, LastChange: now, LastChecked: now, ConsecutiveFailures: failures}
+			continue
+		}
+
+		if previous.Up != up {
+			transitions = append(transitions, Transition{tomcat, up, result.FailureReason})
+			previous.LastChange = now
+		}
+		previous.Up = up
+		previous.LastChecked = now
+		previous.ConsecutiveFailures = failures
+		current[tomcat.ServerID] = previous
+	}
+
+	for _, tomcat := range unchecked {
+		if previous, known := s.Instances[tomcat.ServerID]; known {
+			current[tomcat.ServerID] = previous
+		}
+	}
+
+	// Instances the portal no longer returns are dropped
+	s.Instances = current
+	return transitions
+}
+
+// dampFailures reports the failed liveness results of instances that have failed, but not yet
+// -failuresToAlert runs in a row, as up so a single blip doesn't page anyone. Other failed
+// results are real and pass through. Call it after update.
+func (s *RunState) dampFailures(results []TomcatCheckResult) []TomcatCheckResult {
+	for i, result := range results {
+		if result.ServerStatus || !isLiveness(result.DataType) {
+			continue
+		}
+		state, ok := s.Instances[result.ServerID]
+		if !ok || state.ConsecutiveFailures == 0 || state.ConsecutiveFailures >= *failuresToAlert {
+			continue
+		}
+
+		results[i].ServerStatus = true
+		results[i].FailureReason = fmt.Sprintf("suppressed (%v of %v failures): %v", state.ConsecutiveFailures, *failuresToAlert, result.FailureReason)
+	}
+	return results
+}
+
+// httpStatuses picks out the HTTP check result of every instance
+func httpStatuses(results []TomcatCheckResult) map[string]TomcatCheckResult {
+	statuses := make(map[string]TomcatCheckResult)
+	for _, result := range results {
+		if result.DataType == "time" {
+			statuses[result.ServerID] = result
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+func TestDampFailuresOnlyLiveness(t *testing.T) {
+	defer func(n int) { *failuresToAlert = n }(*failuresToAlert)
+	*failuresToAlert = 3
+
+	state := &RunState{Instances: map[string]*InstanceState{
+		"blip": {Up: true, ConsecutiveFailures: 1},
+		"down": {Up: false, ConsecutiveFailures: 3},
+		"ok":   {Up: true, ConsecutiveFailures: 0},
+	}}
+	results := state.dampFailures([]TomcatCheckResult{
+		{ServerID: "blip", DataType: "time"},
+		{ServerID: "blip", DataType: "time_1"},
+		{ServerID: "blip", DataType: "tcp"},
+		{ServerID: "blip", DataType: "jmx_port9001"},
+		{ServerID: "blip", DataType: "config_drift"},
+		{ServerID: "down", DataType: "time"},
+		{ServerID: "ok", DataType: "jmx"},
+	})
+
+	want := []bool{true, true, true, true, false, false, false}
+	for i, result := range results {
+		if result.ServerStatus != want[i] {
+			t.Errorf("%v %v: ServerStatus %v, want %v", result.ServerID, result.DataType, result.ServerStatus, want[i])
+		}
+	}
+}
+
+func TestCheckInstanceDrop(t *testing.T) {
+	defer func(threshold float64, proceed bool) {
+		*instanceDropThreshold, *proceedOnInstanceDrop = threshold, proceed
+	}(*instanceDropThreshold, *proceedOnInstanceDrop)
+	*instanceDropThreshold, *proceedOnInstanceDrop = 50, false
+
+	state := &RunState{InstanceCount: 100}
+	if err := state.checkInstanceDrop(40); err == nil {
+		t.Error("no error for a 60% drop")
+	}
+	if err := state.checkInstanceDrop(30); err != nil {
+		t.Errorf("a 25%% drop: %v", err)
+	}
+
+	*proceedOnInstanceDrop = true
+	state.InstanceCount = 100
+	if err := state.checkInstanceDrop(10); err != nil {
+		t.Errorf("-proceedOnInstanceDrop: %v", err)
+	}
+}
+
+func TestUpdateKeepsSampledOutInstances(t *testing.T) {
+	defer func(n int) { *failuresToAlert = n }(*failuresToAlert)
+	*failuresToAlert = 1
+
+	app1 := TomcatInstance{ServerID: "app1", ProjectID: "p1"}
+	app2 := TomcatInstance{ServerID: "app2", ProjectID: "p1"}
+	state := &RunState{Instances: make(map[string]*InstanceState)}
+	state.update([]TomcatInstance{app1, app2}, nil, []TomcatCheckResult{
+		newCheckResult(app1, true, "time", "100"),
+		newCheckResult(app2, false, "time", "0"),
+	})
+
+	// Each run samples the other instance of the project
+	state.update([]TomcatInstance{app1}, []TomcatInstance{app2}, []TomcatCheckResult{newCheckResult(app1, true, "time", "100")})
+	if previous, ok := state.Instances["app2"]; !ok || previous.Up {
+		t.Fatalf("sampled out app2 is %+v, want its down state kept", previous)
+	}
+
+	transitions := state.update([]TomcatInstance{app2}, []TomcatInstance{app1}, []TomcatCheckResult{newCheckResult(app2, true, "time", "100")})
+	if len(transitions) != 1 || transitions[0].Instance.ServerID != "app2" || !transitions[0].Up {
+		t.Errorf("got transitions %+v, want app2 coming back up", transitions)
+	}
+	if _, ok := state.Instances["app1"]; !ok {
+		t.Error("sampled out app1 was dropped")
+	}
+}
+
+func TestUpdateKeepsInstancesMissingAddress(t *testing.T) {
+	app1 := TomcatInstance{ServerID: "app1", ServerIP: "10.0.0.1", HTTPPort: "8080"}
+	state := &RunState{Instances: map[string]*InstanceState{
+		"app1": {Up: true},
+		"app2": {Up: false, ConsecutiveFailures: 4},
+	}}
+
+	// The portal lost app2's address for a run
+	checkable, missing := splitCheckable([]TomcatInstance{app1, {ServerID: "app2", HTTPPort: "8080"}})
+	state.update(checkable, missing, []TomcatCheckResult{newCheckResult(app1, true, "time", "100")})
+
+	if previous, ok := state.Instances["app2"]; !ok || previous.Up || previous.ConsecutiveFailures != 4 {
+		t.Errorf("app2 is %+v, want its state from before it lost its address", previous)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+var jmxNonCritical = flag.Bool("jmxNonCritical", false, "an instance whose HTTP check passes is up even when JMX collection fails")
+
+// isJmxFailure tells whether a result is a failed JMX collection, of the platform JVM or of any
+// other port in JmxPorts
+func isJmxFailure(result TomcatCheckResult) bool {
+	return isJmxDataType(result.DataType) && !result.ServerStatus
+}
+
+// isLiveness tells whether a DataType reports the instance up or down rather than a metric: the
+// HTTP check and its per-URL copies, the TCP probe and JMX collection
+func isLiveness(dataType string) bool {
+	return dataType == "time" || strings.HasPrefix(dataType, "time_") || dataType == "tcp" || isJmxDataType(dataType)
+}
+
+// isJmxDataType matches the "jmx" result and its "jmx_port<port>" copies
+func isJmxDataType(dataType string) bool {
+	return dataType == "jmx" || strings.HasPrefix(dataType, "jmx_port")
+}
+
+// instanceStatuses works out whether each instance is up overall, represented by its HTTP check
+// result. By default the HTTP check and JMX collection count independently, so a failure of
+// either makes the instance down. With -jmxNonCritical only the HTTP check counts.
+func instanceStatuses(results []TomcatCheckResult) map[string]TomcatCheckResult {
+	statuses := httpStatuses(results)
+	if *jmxNonCritical {
+		return statuses
+	}
+
+	for _, result := range results {
+		status, ok := statuses[result.ServerID]
+		if !ok || !status.ServerStatus || !isJmxFailure(result) {
+			continue
+		}
+		status.ServerStatus = false
+		status.FailureReason = "jmx: " + result.FailureReason
+		statuses[result.ServerID] = status
+	}
+	return statuses
+}
+
+// dropNonCriticalJmxFailures removes the failed JMX results of instances whose HTTP check
+// passed, so with -jmxNonCritical the portal just sees their JMX data as missing
+func dropNonCriticalJmxFailures(results []TomcatCheckResult) []TomcatCheckResult {
+	statuses := httpStatuses(results)
+
+	kept := results[:0]
+	for _, result := range results {
+		if isJmxFailure(result) && statuses[result.ServerID].ServerStatus {
+			logger.Debug("Ignoring non-critical JMX failure of", result.ServerID, result.ServerResponse)
+			continue
+		}
+		kept = append(kept, result)
+	}
+	return kept
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestInstanceStatusesSecondJmxPort(t *testing.T) {
+	results := []TomcatCheckResult{
+		{ServerID: "app1", ServerStatus: true, DataType: "time", ServerResponse: "120"},
+		{ServerID: "app1", ServerStatus: true, DataType: "threads", ServerResponse: "80"},
+		{ServerID: "app1", ServerStatus: false, DataType: "jmx_port9001", ServerResponse: "jolokia_down"},
+	}
+
+	status := instanceStatuses(results)["app1"]
+	if status.ServerStatus {
+		t.Error("a failed JMX read of the second port should take the instance down")
+	}
+
+	defer func(nonCritical bool) { *jmxNonCritical = nonCritical }(*jmxNonCritical)
+	*jmxNonCritical = true
+	kept := dropNonCriticalJmxFailures(append([]TomcatCheckResult(nil), results...))
+	if len(kept) != 2 {
+		t.Errorf("kept %v results, want the jmx_port9001 failure dropped: %+v", len(kept), kept)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+)
+
+// streamable tells whether a batch can be streamed to the admin portal. Renamed fields,
+// grouping and -postProcess all need the whole payload in hand, so they don't stream.
+func streamable() bool {
+	return len(portalFields) == 0 && !*groupByServer && len(*postProcessCommand) == 0
+}
+
+// writePayload writes results as a JSON array, encoding one result at a time
+func writePayload(w io.Writer, results []TomcatCheckResult) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	for i, result := range results {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+// countingWriter throws away what's written, keeping only its length
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// payloadSize encodes the payload once without keeping it, so the request can carry a
+// Content-Length and the gzip threshold still applies
+func payloadSize(results []TomcatCheckResult) (int64, error) {
+	var counter countingWriter
+	if err := writePayload(&counter, results); err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
+
+// streamPayload returns the payload as it's encoded, gzipped when compressed. When the
+// request gives up early the transport closes the reader, which stops the encoding.
+func streamPayload(results []TomcatCheckResult, compressed bool) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		if !compressed {
+			pw.CloseWithError(writePayload(pw, results))
+			return
+		}
+
+		zw := gzip.NewWriter(pw)
+		err := writePayload(zw, results)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// loggedPayload is a streamed payload as it shows up in the debug log. It's only encoded when
+// the log level lets the line through.
+type loggedPayload []TomcatCheckResult
+
+func (p loggedPayload) String() string {
+	var buf bytes.Buffer
+	if err := writePayload(&buf, p); err != nil {
+		return err.Error()
+	}
+	return redactLog(buf.String())
+}
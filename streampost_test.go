@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostResultsAuthFailureStopsStreaming(t *testing.T) {
+	defer func(tokenURL, id, secret string) {
+		*oauthTokenURL, *oauthClientID, *oauthClientSecret = tokenURL, id, secret
+	}(*oauthTokenURL, *oauthClientID, *oauthClientSecret)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	*oauthTokenURL, *oauthClientID, *oauthClientSecret = server.URL, "cron", "hunter2"
+
+	if !streamable() {
+		t.Fatal("the default payload should stream")
+	}
+	results := []TomcatCheckResult{{ServerID: "app1", ServerStatus: true, DataType: "time", ServerResponse: "120"}}
+
+	for i := 0; i < 10; i++ {
+		if err := postResults(results); err == nil {
+			t.Fatal("posted without a token")
+		}
+	}
+
+	// Every streaming encoder has to notice its body was abandoned. They're looked for by name,
+	// a goroutine count would also catch the token request's idle keep-alive connection.
+	deadline := time.Now().Add(2 * time.Second)
+	n := streamingEncoders()
+	for n > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		n = streamingEncoders()
+	}
+	if n > 0 {
+		t.Errorf("%v streaming encoders left behind by failed posts", n)
+	}
+}
+
+// streamingEncoders counts the goroutines still running a streamPayload encoder
+func streamingEncoders() int {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	return strings.Count(string(buf), "streamPayload.func")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var summary = flag.Bool("summary", false, "log a summary of the run when it finishes")
+
+// responseTimeBuckets are the upper bounds of the response time histogram, the last bucket is open ended
+var responseTimeBuckets = []struct {
+	label string
+	limit time.Duration
+}{
+	{"<100ms", 100 * time.Millisecond},
+	{"100-500ms", 500 * time.Millisecond},
+	{"500ms-1s", time.Second},
+	{">1s", 0},
+}
+
+// logSummary logs up/down counts and a histogram of the HTTP response times
+func logSummary(instances []TomcatInstance, results []TomcatCheckResult, elapsed time.Duration) {
+	up, down, timed := 0, 0, 0
+	counts := make([]int, len(responseTimeBuckets))
+
+	for _, result := range instanceStatuses(results) {
+		if result.ServerStatus {
+			up++
+		} else {
+			down++
+		}
+	}
+
+	for _, result := range httpStatuses(results) {
+		if !result.ServerStatus {
+			continue
+		}
+
+		responseTime, err := parseElapsed(result.ServerResponse)
+		if err != nil {
+			continue
+		}
+		counts[responseTimeBucket(responseTime)]++
+		timed++
+	}
+
+	logger.Infof("Checked %v instances in %v: %v up, %v down, %v skipped", len(instances), elapsed.Round(time.Millisecond), up, down, countSkipped(results))
+	if availability, ok := weightedAvailability(instances, results); ok {
+		logger.Infof("Weighted availability: %.1f%%", availability)
+	}
+	logDatacenterCounts(instances, results)
+	logger.Info("HTTP response times:")
+	for i, bucket := range responseTimeBuckets {
+		logger.Info(histogramLine(bucket.label, counts[i], timed))
+	}
+}
+
+// weightedAvailability is the percentage of the fleet that is up, counting each checked
+// instance by its Weight so prod can count for more than dev
+func weightedAvailability(instances []TomcatInstance, results []TomcatCheckResult) (float64, bool) {
+	statuses := instanceStatuses(results)
+
+	var upWeight, totalWeight float64
+	for _, tomcat := range instances {
+		result, ok := statuses[tomcat.ServerID]
+		if !ok {
+			continue
+		}
+		weight := instanceWeight(tomcat)
+		totalWeight += weight
+		if result.ServerStatus {
+			upWeight += weight
+		}
+	}
+
+	if totalWeight == 0 {
+		return 0, false
+	}
+	return upWeight / totalWeight * 100, true
+}
+
+func instanceWeight(tomcat TomcatInstance) float64 {
+	if tomcat.Weight > 0 {
+		return tomcat.Weight
+	}
+	return 1
+}
+
+func responseTimeBucket(d time.Duration) int {
+	for i, bucket := range responseTimeBuckets {
+		if bucket.limit == 0 || d < bucket.limit {
+			return i
+		}
+	}
+	return len(responseTimeBuckets) - 1
+}
+
+// histogramLine draws one bar scaled to 40 characters for the whole fleet
+func histogramLine(label string, count int, total int) string {
+	width := 0
+	if total > 0 {
+		width = count * 40 / total
+	}
+	if count > 0 && width == 0 {
+		width = 1
+	}
+	return fmt.Sprintf("  %-10s %5d %s", label, count, strings.Repeat("#", width))
+}
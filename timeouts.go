@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// defaultHTTPTimeout applies to HTTP checks of projects without an override
+const defaultHTTPTimeout = 5 * time.Second
+
+var projectTimeoutsFile = flag.String("projectTimeouts", "", "JSON file mapping ProjectName or ProjectID to {\"http\": seconds, \"jmx\": seconds}")
+
+// ProjectTimeout overrides the check timeouts of one project, in seconds. Zero keeps the default.
+type ProjectTimeout struct {
+	HTTP int `json:"http"`
+	JMX  int `json:"jmx"`
+}
+
+var projectTimeouts map[string]ProjectTimeout
+
+var adaptiveTimeoutShare = flag.Float64("adaptiveTimeout", 0.25, "with -interval and no -timeout, the share of the interval the probes' timeouts may add up to, 0 to keep the defaults")
+
+// adaptiveTimeout caps the HTTP and JMX timeouts in daemon mode, 0 leaves them alone
+var adaptiveTimeout time.Duration
+
+// deriveTimeouts fits the default timeouts into -interval, so runs can't overlap because a
+// stuck instance waits out a timeout longer than the interval. The probes run one after
+// another, so the share of the interval is split between them. An explicit -timeout wins,
+// and a derived timeout only ever shortens the defaults.
+func deriveTimeouts() error {
+	if *adaptiveTimeoutShare < 0 || *adaptiveTimeoutShare > 1 {
+		return fmt.Errorf("Invalid -adaptiveTimeout %v, use a share of the interval between 0 and 1", *adaptiveTimeoutShare)
+	}
+	if *interval <= 0 || *adaptiveTimeoutShare == 0 || flagSet("timeout") {
+		return nil
+	}
+
+	adaptiveTimeout = time.Duration(float64(*interval) * *adaptiveTimeoutShare / float64(len(probeOrder())))
+	httpTimeout, jmxTimeout := timeoutsFor(TomcatInstance{})
+	logger.Infof("Timeouts derived from the %v interval: HTTP %v, JMX %v", *interval, httpTimeout, jmxTimeout)
+	return nil
+}
+
+// flagSet tells whether a flag was given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// loadProjectTimeouts reads the -projectTimeouts file
+func loadProjectTimeouts() error {
+	data, err := ioutil.ReadFile(*projectTimeoutsFile)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &projectTimeouts)
+}
+
+// timeoutsFor returns the HTTP and JMX timeouts of an instance. ProjectID wins over ProjectName
+// when both are listed, projects that aren't listed get the global defaults, fitted into
+// -interval by -adaptiveTimeout.
+func timeoutsFor(tomcat TomcatInstance) (httpTimeout time.Duration, jmxTimeout time.Duration) {
+	httpTimeout = defaultHTTPTimeout
+	jmxTimeout = time.Duration(*jolokiaTimeout) * time.Second
+	if adaptiveTimeout > 0 && adaptiveTimeout < httpTimeout {
+		httpTimeout = adaptiveTimeout
+	}
+	if adaptiveTimeout > 0 && adaptiveTimeout < jmxTimeout {
+		jmxTimeout = adaptiveTimeout
+	}
+
+	override, ok := projectTimeouts[tomcat.ProjectID]
+	if !ok {
+		override, ok = projectTimeouts[tomcat.ProjectName]
+	}
+	if !ok {
+		return
+	}
+
+	if override.HTTP > 0 {
+		httpTimeout = time.Duration(override.HTTP) * time.Second
+	}
+	if override.JMX > 0 {
+		jmxTimeout = time.Duration(override.JMX) * time.Second
+	}
+	return
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// The admin portal has always been sent HTTP response times in microseconds, keep "us" unless
+// the portal is changed to expect something else
+var timeUnit = flag.String("timeUnit", "us", "unit of reported response times: ms, us or ns")
+
+var timeUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"us": time.Microsecond,
+	"ns": time.Nanosecond,
+}
+
+func validateTimeUnit() error {
+	if _, ok := timeUnits[*timeUnit]; !ok {
+		return fmt.Errorf("unknown time unit %q, use ms, us or ns", *timeUnit)
+	}
+	return nil
+}
+
+// formatElapsed renders a duration in the configured -timeUnit, truncating to whole units
+func formatElapsed(d time.Duration) string {
+	return strconv.FormatInt(int64(d/timeUnits[*timeUnit]), 10)
+}
+
+// parseElapsed is the inverse of formatElapsed
+func parseElapsed(s string) (time.Duration, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(n) * timeUnits[*timeUnit], nil
+}
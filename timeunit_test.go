@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatElapsed(t *testing.T) {
+	defer func(unit string) { *timeUnit = unit }(*timeUnit)
+
+	tests := []struct {
+		unit    string
+		elapsed time.Duration
+		want    string
+	}{
+		{"us", 1500 * time.Microsecond, "1500"},
+		{"us", 1500 * time.Nanosecond, "1"},
+		{"ms", 1500 * time.Microsecond, "1"},
+		{"ms", 2 * time.Second, "2000"},
+		{"ns", 1500 * time.Microsecond, "1500000"},
+		{"ns", 0, "0"},
+	}
+
+	for _, tt := range tests {
+		*timeUnit = tt.unit
+		if got := formatElapsed(tt.elapsed); got != tt.want {
+			t.Errorf("formatElapsed(%v) in %v = %v, want %v", tt.elapsed, tt.unit, got, tt.want)
+		}
+
+		parsed, err := parseElapsed(tt.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != tt.elapsed.Truncate(timeUnits[tt.unit]) {
+			t.Errorf("parseElapsed(%v) in %v = %v, want %v", tt.want, tt.unit, parsed, tt.elapsed.Truncate(timeUnits[tt.unit]))
+		}
+	}
+}
+
+func TestValidateTimeUnit(t *testing.T) {
+	defer func(unit string) { *timeUnit = unit }(*timeUnit)
+
+	for unit, valid := range map[string]bool{"ms": true, "us": true, "ns": true, "s": false, "": false} {
+		*timeUnit = unit
+		if err := validateTimeUnit(); (err == nil) != valid {
+			t.Errorf("validateTimeUnit(%q) = %v, want valid %v", unit, err, valid)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var trace = flag.Bool("trace", false, "log every request to the instances, Jolokia and the admin portal with its response, bodies included. Very verbose and logs session data.")
+
+// credentialHeaderNames are the headers that always carry credentials, every -jolokiaHeader
+// is treated as one too since they're usually API keys
+var credentialHeaderNames = []string{"Authorization", "Proxy-Authorization", "X-Auth-Token"}
+
+// credentialHeaders matches the header lines that carry credentials in a dump. It's compiled
+// on first use, once the -jolokiaHeader flags are parsed.
+var credentialHeaders struct {
+	sync.Once
+	pattern *regexp.Regexp
+}
+
+// tokenFields matches the tokens in an OAuth2 token endpoint's answer
+var tokenFields = regexp.MustCompile(`"(access_token|refresh_token|id_token)"\s*:\s*"[^"]*"`)
+
+func credentialHeaderPattern() *regexp.Regexp {
+	credentialHeaders.Do(func() {
+		names := make([]string, 0, len(credentialHeaderNames)+len(jolokiaHeaders))
+		for _, name := range credentialHeaderNames {
+			names = append(names, regexp.QuoteMeta(name))
+		}
+		for name := range jolokiaHeaders {
+			names = append(names, regexp.QuoteMeta(name))
+		}
+		credentialHeaders.pattern = regexp.MustCompile(`(?im)^(` + strings.Join(names, "|") + `):[^\r\n]*`)
+	})
+	return credentialHeaders.pattern
+}
+
+// traceTransport logs the full request and response of every round trip
+type traceTransport struct {
+	transport http.RoundTripper
+}
+
+// traced wraps a transport for -trace, and leaves it alone otherwise
+func traced(transport http.RoundTripper) http.RoundTripper {
+	if !*trace {
+		return transport
+	}
+	return &traceTransport{transport: transport}
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if dump, err := httputil.DumpRequestOut(req, true); err == nil {
+		logger.Info("Trace request:\n", traceText(dump))
+	} else {
+		logger.Info("Trace request ", req.Method, " ", redactLog(req.URL.String()), ", could not dump it: ", err)
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		logger.Info("Trace error: ", redacted(err))
+		return resp, err
+	}
+
+	if dump, err := httputil.DumpResponse(resp, true); err == nil {
+		logger.Info("Trace response:\n", traceText(dump))
+	} else {
+		logger.Info("Trace response ", resp.Status, ", could not dump it: ", err)
+	}
+	return resp, nil
+}
+
+// traceText keeps credentials out of a dump, in the headers and in a token endpoint's answer
+func traceText(dump []byte) string {
+	text := credentialHeaderPattern().ReplaceAllString(string(dump), "$1: REDACTED")
+	text = tokenFields.ReplaceAllString(text, `"$1":"REDACTED"`)
+	return redactLog(text)
+}
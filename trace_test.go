@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTraceWithOAuthDoesNotDeadlock(t *testing.T) {
+	defer func(tokenURL, id, secret string, traceOn bool, transport http.RoundTripper) {
+		*oauthTokenURL, *oauthClientID, *oauthClientSecret, *trace = tokenURL, id, secret, traceOn
+		portalClient.Transport = transport
+		oauthToken.value, oauthToken.expires = "", time.Time{}
+	}(*oauthTokenURL, *oauthClientID, *oauthClientSecret, *trace, portalClient.Transport)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token":"t0ken-value","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	*oauthTokenURL, *oauthClientID, *oauthClientSecret, *trace = server.URL, "cron", "hunter2", true
+	portalClient.Transport = traced(http.DefaultTransport)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := currentOAuthToken()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetching a token with -trace deadlocked")
+	}
+}
+
+func TestTraceTextRedacts(t *testing.T) {
+	defer func(headers headerFlags) { jolokiaHeaders = headers }(jolokiaHeaders)
+	jolokiaHeaders = headerFlags{}
+	jolokiaHeaders.Set("X-API-Key: s3cret")
+
+	dump := "POST /jolokia HTTP/1.1\r\n" +
+		"Host: proxy\r\n" +
+		"Authorization: Basic Y3JvbjpodW50ZXIy\r\n" +
+		"X-Auth-Token: abc\r\n" +
+		"X-Api-Key: s3cret\r\n" +
+		"\r\n" +
+		`{"access_token": "t0ken-value","token_type":"Bearer"}`
+
+	// The pattern is compiled once, reset it so this test's -jolokiaHeader is picked up
+	credentialHeaders.Once, credentialHeaders.pattern = sync.Once{}, nil
+	text := traceText([]byte(dump))
+	for _, secret := range []string{"Y3Jvbjpod", "abc", "s3cret", "t0ken-value"} {
+		if strings.Contains(text, secret) {
+			t.Errorf("%q is in the trace:\n%v", secret, text)
+		}
+	}
+	if !strings.Contains(text, "Host: proxy") {
+		t.Errorf("the trace lost a header that isn't a credential:\n%v", text)
+	}
+}
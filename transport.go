@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+var connectTimeout = flag.Duration("connectTimeout", 0, "TCP connect timeout for HTTP checks and Jolokia, 0 leaves it to the overall timeout")
+var readTimeout = flag.Duration("readTimeout", 0, "time to wait for response headers after connecting, 0 leaves it to the overall timeout")
+var dnsServer = flag.String("dnsServer", "", "host:port of the DNS server used to resolve instance and Jolokia hostnames, defaults to the system resolver")
+var maxConnsPerHost = flag.Int("maxConnsPerHost", 4, "maximum connections the HTTP checks open to one host:port, 0 for no limit")
+var minTLS = flag.String("minTLS", "1.2", "lowest TLS version accepted from the admin portal, HTTPS checks and Jolokia: 1.0, 1.1, 1.2 or 1.3")
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// minTLSVersion is -minTLS once validated
+var minTLSVersion uint16 = tls.VersionTLS12
+
+// checkTransport is shared by all HTTP checks so connections can be reused. Go pools connections
+// by scheme and host:port, so instances sharing a ServerIP on different ports each get their own
+// limit and can't starve each other.
+var checkTransport *http.Transport
+
+// jolokiaTransport is shared by all Jolokia requests
+var jolokiaTransport *http.Transport
+
+// newDialer applies -connectTimeout. The per-request client timeout still bounds the whole
+// request, the connect and read timeouts just fail faster on the part that is stuck.
+func newDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   *connectTimeout,
+		KeepAlive: 30 * time.Second,
+		Resolver:  newResolver(),
+	}
+}
+
+// newResolver sends every lookup to -dnsServer, for split-horizon DNS where instance names
+// only resolve internally. A nil resolver means the system default.
+func newResolver() *net.Resolver {
+	if *dnsServer == "" {
+		return nil
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 5 * time.Second}
+			return d.DialContext(ctx, network, *dnsServer)
+		},
+	}
+}
+
+func validateMinTLS() error {
+	version, ok := tlsVersions[*minTLS]
+	if !ok {
+		return fmt.Errorf("Invalid -minTLS %q, use 1.0, 1.1, 1.2 or 1.3", *minTLS)
+	}
+	minTLSVersion = version
+	return nil
+}
+
+func newTLSConfig() *tls.Config {
+	return &tls.Config{MinVersion: minTLSVersion}
+}
+
+func newCheckTransport() *http.Transport {
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           tunnelDial(newDialer().DialContext),
+		ResponseHeaderTimeout: *readTimeout,
+		MaxConnsPerHost:       *maxConnsPerHost,
+		MaxIdleConnsPerHost:   *maxConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		TLSClientConfig:       newTLSConfig(),
+	}
+}
+
+func newJolokiaTransport() *http.Transport {
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           tunnelDial(newDialer().DialContext),
+		ResponseHeaderTimeout: *readTimeout,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		TLSClientConfig:       newTLSConfig(),
+	}
+}
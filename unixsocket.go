@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net"
+	"time"
+)
+
+var unixSocket = flag.String("unixSocket", "", "also write the JSON results to a local consumer listening on this Unix socket")
+
+// unixSocketAttempts and unixSocketRetryWait give a sidecar that is still starting up a moment to listen
+const unixSocketAttempts = 3
+const unixSocketRetryWait = 500 * time.Millisecond
+
+// writeToUnixSocket sends the results as one JSON array followed by a newline. A consumer
+// that isn't listening only gets a warning, it never fails the run.
+func writeToUnixSocket(results []TomcatCheckResult) {
+	jsonData, err := json.Marshal(results)
+	if err != nil {
+		logger.Error("Could not marshal results for unix socket", err)
+		return
+	}
+
+	var conn net.Conn
+	for attempt := 1; attempt <= unixSocketAttempts; attempt++ {
+		conn, err = net.DialTimeout("unix", *unixSocket, time.Second)
+		if err == nil {
+			break
+		}
+		logger.Debugf("Unix socket %v not ready (attempt %v): %v", *unixSocket, attempt, err)
+		time.Sleep(unixSocketRetryWait)
+	}
+	if err != nil {
+		logger.Warningf("Nothing listening on unix socket %v, results not sent: %v", *unixSocket, err)
+		return
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(append(jsonData, '\n')); err != nil {
+		logger.Warning("Could not write results to unix socket", err)
+	}
+}
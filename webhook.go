@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+var webhookURL = flag.String("webhook", "", "Slack-compatible webhook notified when an instance goes up or down (needs -stateFile)")
+
+// webhookMessage is the Slack incoming-webhook payload
+type webhookMessage struct {
+	Text string `json:"text"`
+}
+
+// notifyTransitions posts one message per instance that changed state. Failures are only logged.
+func notifyTransitions(transitions []Transition) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	for _, transition := range transitions {
+		jsonData, err := json.Marshal(webhookMessage{transitionText(transition)})
+		if err != nil {
+			logger.Error("Could not marshal webhook message", err)
+			continue
+		}
+
+		req, _ := http.NewRequest("POST", *webhookURL, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", cronUserAgent)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			logger.Error("Could not send webhook", err)
+			continue
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			logger.Errorf("Bad webhook response: %v", resp.Status)
+		}
+	}
+}
+
+func transitionText(transition Transition) string {
+	tomcat := transition.Instance
+	if transition.Up {
+		return fmt.Sprintf(":white_check_mark: %v (%v) is back UP", tomcat.ServerID, tomcat.ProjectName)
+	}
+
+	reason := transition.Reason
+	if reason == "" {
+		reason = "unknown"
+	}
+	return fmt.Sprintf(":rotating_light: %v (%v) is DOWN: %v", tomcat.ServerID, tomcat.ProjectName, reason)
+}